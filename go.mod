@@ -10,6 +10,7 @@ require (
 	github.com/playwright-community/playwright-go v0.5200.0
 	github.com/subsan/uafaker v1.1.236
 	github.com/temoto/robotstxt v1.1.2
+	golang.org/x/net v0.40.0
 )
 
 require (
@@ -0,0 +1,236 @@
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+)
+
+type State int
+
+const (
+	Closed   State = iota // requests pass
+	Open                  // requests are paused
+	HalfOpen              // a few probe requests pass to test whether the errors stopped
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type Options struct {
+	Window          time.Duration              // outcomes older than the window are forgotten
+	MinRequests     int                        // the breaker doesn't open before the window holds this many outcomes
+	Threshold       float64                    // error rate of a single class that opens the breaker
+	ClassThresholds map[crawlerr.Class]float64 // overrides Threshold per class, 0 never opens the breaker
+	OpenDuration    time.Duration              // how long the breaker stays open before probing
+	Probes          int                        // successful probes needed to close the breaker again
+	now             func() time.Time
+}
+
+// Unlike captchas, missing pages and robots.txt rules don't hint at being blocked.
+func DefaultClassThresholds() map[crawlerr.Class]float64 {
+	return map[crawlerr.Class]float64{
+		crawlerr.Captcha:         0.2,
+		crawlerr.HTTPClient:      0,
+		crawlerr.RobotsForbidden: 0,
+		crawlerr.SignIn:          0,
+		crawlerr.Gone:            0,
+	}
+}
+
+type outcome struct {
+	at    time.Time
+	class crawlerr.Class // empty on success
+}
+
+// A Breaker tracks the error rates per error class over a sliding time window.
+// It opens once the rate of any class exceeds its threshold, which pauses fetching until the
+// open duration passed. Afterwards a few probes are let through before it closes again.
+type Breaker struct {
+	Options
+	log       *slog.Logger
+	mu        sync.Mutex
+	outcomes  []outcome // ordered by time
+	state     State
+	openedAt  time.Time
+	probes    int // probes let through in the half-open state
+	successes int // successful probes in the half-open state
+}
+
+func New(opts Options) *Breaker {
+	if opts.Window <= 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 0.5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 2 * time.Minute
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = 3
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	thresholds := DefaultClassThresholds()
+	for class, t := range opts.ClassThresholds {
+		thresholds[class] = t
+	}
+	opts.ClassThresholds = thresholds
+
+	return &Breaker{
+		Options: opts,
+		log:     internal.NewLogger("CircuitBreaker"),
+	}
+}
+
+// Reports whether a request may pass.
+// In the half-open state every allowed request counts as a probe.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.OpenDuration {
+		b.transition(HalfOpen)
+	}
+
+	switch b.state {
+	case Closed:
+		return true
+	case HalfOpen:
+		if b.probes < b.Probes {
+			b.probes++
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Gives back a probe that was allowed but never sent, e.g. because there was nothing to fetch.
+// Without it the unused probes would keep the breaker half-open forever.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen && b.probes > b.successes {
+		b.probes--
+	}
+}
+
+// Blocks until a request may pass or ctx is done.
+func (b *Breaker) Wait(ctx context.Context, pollInterval time.Duration) error {
+	for !b.Allow() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.record("")
+}
+
+func (b *Breaker) Failure(class crawlerr.Class) {
+	b.record(class)
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) record(class crawlerr.Class) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.outcomes = append(b.outcomes, outcome{at: now, class: class})
+	b.prune(now)
+
+	switch b.state {
+	case HalfOpen:
+		if class != "" && b.threshold(class) > 0 {
+			b.transition(Open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.Probes {
+			// errors from before the pause shouldn't open the breaker again right away
+			b.outcomes = nil
+			b.transition(Closed)
+		}
+	case Closed:
+		if class == "" || b.threshold(class) == 0 {
+			return
+		}
+		if rate := b.rate(class); len(b.outcomes) >= b.MinRequests && rate >= b.threshold(class) {
+			b.log.Warn(fmt.Sprintf("%s error rate of %.2f exceeds threshold", class, rate))
+			b.transition(Open)
+		}
+	}
+}
+
+func (b *Breaker) transition(state State) {
+	b.log.Info(fmt.Sprintf("%s -> %s", b.state, state))
+	b.state = state
+	b.probes = 0
+	b.successes = 0
+	if state == Open {
+		b.openedAt = b.now()
+	}
+}
+
+// Drops outcomes that left the window.
+func (b *Breaker) prune(now time.Time) {
+	i := 0
+	for i < len(b.outcomes) && now.Sub(b.outcomes[i].at) > b.Window {
+		i++
+	}
+	b.outcomes = b.outcomes[i:]
+}
+
+func (b *Breaker) rate(class crawlerr.Class) float64 {
+	if len(b.outcomes) == 0 {
+		return 0
+	}
+	count := 0
+	for _, o := range b.outcomes {
+		if o.class == class {
+			count++
+		}
+	}
+	return float64(count) / float64(len(b.outcomes))
+}
+
+func (b *Breaker) threshold(class crawlerr.Class) float64 {
+	if t, ok := b.ClassThresholds[class]; ok {
+		return t
+	}
+	return b.Threshold
+}
@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestBreaker(c *clock) *Breaker {
+	return New(Options{
+		Window:       time.Minute,
+		MinRequests:  4,
+		Threshold:    0.5,
+		OpenDuration: 30 * time.Second,
+		Probes:       2,
+		now:          c.now,
+	})
+}
+
+func TestBreakerOpensOnErrorRate(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	b.Success()
+	b.Failure(crawlerr.Timeout)
+	b.Success()
+	if b.State() != Closed {
+		t.Fatalf("expected closed below min requests, got %s", b.State())
+	}
+
+	b.Failure(crawlerr.Timeout)
+	if b.State() != Open {
+		t.Fatalf("expected open at 50%% timeouts, got %s", b.State())
+	}
+	if b.Allow() {
+		t.Error("open breaker allowed a request")
+	}
+}
+
+func TestBreakerIgnoresDisabledClasses(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	for range 10 {
+		b.Failure(crawlerr.HTTPClient)
+	}
+	if b.State() != Closed {
+		t.Fatalf("expected 404s to never open the breaker, got %s", b.State())
+	}
+}
+
+func TestBreakerRatesArePerClass(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	// 66% errors in total, but neither timeouts nor parse errors cross 50%
+	for _, class := range []crawlerr.Class{"", "", crawlerr.Timeout, crawlerr.Parse, crawlerr.Timeout, crawlerr.Parse} {
+		if class == "" {
+			b.Success()
+		} else {
+			b.Failure(class)
+		}
+	}
+	if b.State() != Closed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+}
+
+func TestBreakerForgetsOldOutcomes(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	b.Failure(crawlerr.Timeout)
+	b.Failure(crawlerr.Timeout)
+	c.advance(2 * time.Minute)
+	b.Success()
+	b.Success()
+	b.Success()
+	b.Failure(crawlerr.Timeout)
+	if b.State() != Closed {
+		t.Fatalf("expected closed after old failures left the window, got %s", b.State())
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+	for range 4 {
+		b.Failure(crawlerr.Captcha)
+	}
+
+	c.advance(30 * time.Second)
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("expected probes to pass after the open duration")
+	}
+	if b.Allow() {
+		t.Error("allowed more probes than configured")
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("expected half-open, got %s", b.State())
+	}
+
+	// a failed probe opens the breaker again
+	b.Failure(crawlerr.Captcha)
+	if b.State() != Open {
+		t.Fatalf("expected open after failed probe, got %s", b.State())
+	}
+
+	c.advance(30 * time.Second)
+	b.Allow()
+	b.Success()
+	b.Success()
+	if b.State() != Closed {
+		t.Fatalf("expected closed after successful probes, got %s", b.State())
+	}
+}
+
+func TestBreakerReleasedProbes(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+	for range 4 {
+		b.Failure(crawlerr.Captcha)
+	}
+
+	c.advance(30 * time.Second)
+	// probes allowed while the queue was empty
+	for range 3 {
+		if !b.Allow() {
+			t.Fatal("expected a released probe to be allowed again")
+		}
+		b.Release()
+	}
+
+	b.Allow()
+	b.Success()
+	b.Allow()
+	b.Success()
+	if b.State() != Closed {
+		t.Fatalf("expected closed after successful probes, got %s", b.State())
+	}
+}
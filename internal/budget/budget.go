@@ -0,0 +1,175 @@
+package budget
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	Stop      Action = "stop"      // the crawler stops once a limit is reached
+	Discovery Action = "discovery" // no new urls are queued once a limit is reached, the queue is still crawled
+)
+
+// Bounds a crawl, a limit of 0 disables it.
+type Limits struct {
+	Products      int64         // consumed products
+	Pages         int64         // fetched pages, including failed ones
+	Bytes         int64         // bytes received, including the resources of pages
+	Duration      time.Duration // wall-clock time since the run started
+	CategoryQuota int64         // consumed products per top level category
+	SeedQuota     int64         // consumed products per seed
+	Action        Action        // what happens once one of the global limits is reached
+	Run           string        // usage is counted per run, a new run starts from zero
+}
+
+func (l Limits) Validate() error {
+	if l.Action != Stop && l.Action != Discovery {
+		return fmt.Errorf("invalid budget action %q, expected stop or discovery", l.Action)
+	}
+	if l.Run == "" {
+		return fmt.Errorf("budget run is empty")
+	}
+	return nil
+}
+
+// Counters keyed by the budget they count against.
+type Usage map[string]int64
+
+const (
+	Products = "products"
+	Pages    = "pages"
+	Bytes    = "bytes"
+	Seconds  = "seconds"
+)
+
+const (
+	categoryPrefix = "category:"
+	seedPrefix     = "seed:"
+)
+
+func CategoryKey(category string) string {
+	return categoryPrefix + category
+}
+
+func SeedKey(seed string) string {
+	return seedPrefix + seed
+}
+
+// Keeps track of the usage of all instances, as persisted in storage.
+type Tracker struct {
+	limits Limits
+	mu     sync.RWMutex
+	usage  Usage
+}
+
+func New(limits Limits) *Tracker {
+	return &Tracker{
+		limits: limits,
+		usage:  make(Usage),
+	}
+}
+
+func (t *Tracker) Limits() Limits {
+	return t.limits
+}
+
+// Replaces the counters with the totals read from storage.
+func (t *Tracker) Set(totals Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	maps.Copy(t.usage, totals)
+}
+
+// Returns the first global limit that is reached.
+func (t *Tracker) Exhausted() (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, limit := range t.global() {
+		if limit.Limit > 0 && limit.Used >= limit.Limit {
+			return limit.Name, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether the top level category or the seed has consumed its quota.
+// Categories are ordered from the top level down, like the breadcrumbs of a product.
+func (t *Tracker) QuotaReached(categories []string, seed string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.limits.CategoryQuota > 0 && len(categories) > 0 && t.usage[CategoryKey(categories[0])] >= t.limits.CategoryQuota {
+		return true
+	}
+	return t.seedFull(seed)
+}
+
+// Reports whether the seed has consumed its quota, its links aren't followed anymore.
+func (t *Tracker) SeedFull(seed string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.seedFull(seed)
+}
+
+// must be called with mu held
+func (t *Tracker) seedFull(seed string) bool {
+	return t.limits.SeedQuota > 0 && seed != "" && t.usage[SeedKey(seed)] >= t.limits.SeedQuota
+}
+
+type Progress struct {
+	Name  string `json:"name"`
+	Used  int64  `json:"used"`
+	Limit int64  `json:"limit,omitempty"` // 0 if unlimited
+}
+
+// must be called with mu held
+func (t *Tracker) global() []Progress {
+	return []Progress{
+		{Products, t.usage[Products], t.limits.Products},
+		{Pages, t.usage[Pages], t.limits.Pages},
+		{Bytes, t.usage[Bytes], t.limits.Bytes},
+		{Seconds, t.usage[Seconds], int64(t.limits.Duration.Seconds())},
+	}
+}
+
+type Stats struct {
+	Run        string           `json:"run"`
+	Action     Action           `json:"action"`
+	Exhausted  string           `json:"exhausted,omitempty"` // the reached global limit
+	Budgets    []Progress       `json:"budgets"`
+	Categories map[string]int64 `json:"categories,omitempty"`
+	Seeds      map[string]int64 `json:"seeds,omitempty"`
+	Quotas     map[string]int64 `json:"quotas,omitempty"`
+}
+
+// Returns the progress against each budget.
+func (t *Tracker) Stats() Stats {
+	exhausted, _ := t.Exhausted()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	stats := Stats{
+		Run:        t.limits.Run,
+		Action:     t.limits.Action,
+		Exhausted:  exhausted,
+		Budgets:    t.global(),
+		Categories: make(map[string]int64),
+		Seeds:      make(map[string]int64),
+		Quotas: map[string]int64{
+			"category": t.limits.CategoryQuota,
+			"seed":     t.limits.SeedQuota,
+		},
+	}
+	for key, used := range t.usage {
+		if category, ok := strings.CutPrefix(key, categoryPrefix); ok {
+			stats.Categories[category] = used
+		} else if seed, ok := strings.CutPrefix(key, seedPrefix); ok {
+			stats.Seeds[seed] = used
+		}
+	}
+	return stats
+}
@@ -0,0 +1,80 @@
+package budget
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExhausted(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		usage    Usage
+		expected string
+	}{
+		{"unlimited", Limits{}, Usage{Products: 1000, Pages: 5000}, ""},
+		{"below limits", Limits{Products: 100, Pages: 500}, Usage{Products: 99, Pages: 10}, ""},
+		{"products", Limits{Products: 100}, Usage{Products: 100}, Products},
+		{"pages", Limits{Products: 100, Pages: 500}, Usage{Products: 10, Pages: 501}, Pages},
+		{"bytes", Limits{Bytes: 1 << 20}, Usage{Bytes: 2 << 20}, Bytes},
+		{"duration", Limits{Duration: time.Hour}, Usage{Seconds: 3600}, Seconds},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := New(test.limits)
+			tracker.Set(test.usage)
+			name, ok := tracker.Exhausted()
+			if name != test.expected || ok != (test.expected != "") {
+				t.Errorf("Exhausted() = %q, %v; want %q", name, ok, test.expected)
+			}
+		})
+	}
+}
+
+func TestQuotaReached(t *testing.T) {
+	tracker := New(Limits{CategoryQuota: 10, SeedQuota: 20})
+	tracker.Set(Usage{
+		CategoryKey("Toys & Games"):                 10,
+		CategoryKey("Baby"):                         3,
+		SeedKey("https://www.amazon.com/s?k=lego"):  20,
+		SeedKey("https://www.amazon.com/s?k=duplo"): 5,
+	})
+
+	tests := []struct {
+		name       string
+		categories []string
+		seed       string
+		expected   bool
+	}{
+		{"full category", []string{"Toys & Games", "Building Toys"}, "https://www.amazon.com/s?k=duplo", true},
+		{"only the top level category counts", []string{"Baby", "Toys & Games"}, "https://www.amazon.com/s?k=duplo", false},
+		{"full seed", []string{"Baby"}, "https://www.amazon.com/s?k=lego", true},
+		{"unknown category and seed", nil, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := tracker.QuotaReached(test.categories, test.seed); result != test.expected {
+				t.Errorf("QuotaReached(%v, %q) = %v; want %v", test.categories, test.seed, result, test.expected)
+			}
+		})
+	}
+
+	if !tracker.SeedFull("https://www.amazon.com/s?k=lego") || tracker.SeedFull("https://www.amazon.com/s?k=duplo") {
+		t.Error("SeedFull() doesn't match the seed quota")
+	}
+}
+
+func TestStats(t *testing.T) {
+	tracker := New(Limits{Products: 100, Action: Stop})
+	tracker.Set(Usage{Products: 100, CategoryKey("Baby"): 60, SeedKey("https://www.amazon.com/s?k=lego"): 40})
+
+	stats := tracker.Stats()
+	if stats.Exhausted != Products {
+		t.Errorf("Exhausted = %q; want %q", stats.Exhausted, Products)
+	}
+	if stats.Categories["Baby"] != 60 || stats.Seeds["https://www.amazon.com/s?k=lego"] != 40 {
+		t.Errorf("unexpected quota usage %v %v", stats.Categories, stats.Seeds)
+	}
+}
@@ -0,0 +1,232 @@
+package camoufox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Options passed to camoufox.server.launch_server.
+// See https://camoufox.com/python/usage for their meaning.
+type LaunchOptions struct {
+	Port         int    // 0 picks a free port
+	WSPath       string // path of the websocket endpoint
+	Headless     string // "virtual" runs headful in a virtual display, or "true", "false"
+	OS           string // windows, macos or linux
+	Locale       string
+	ScreenWidth  int // maximum width of the generated screen fingerprint
+	ScreenHeight int
+	BlockImages  bool
+	GeoIP        bool // derive geolocation, timezone and locale from the proxy ip
+	UserAgent    string
+	Proxy        *Proxy
+	Config       map[string]any // additional Camoufox config properties, e.g. "mediaDevices:enabled"
+}
+
+type Proxy struct {
+	Server   string
+	Username string
+	Password string
+}
+
+func DefaultLaunchOptions() LaunchOptions {
+	return LaunchOptions{
+		WSPath:       "play",
+		Headless:     "virtual",
+		OS:           "windows",
+		Locale:       "en-US",
+		ScreenWidth:  1920,
+		ScreenHeight: 1080,
+		BlockImages:  true,
+		GeoIP:        true,
+		Config: map[string]any{
+			"mediaDevices:enabled": true,
+		},
+	}
+}
+
+var operatingSystems = []string{"windows", "macos", "linux"}
+
+func (o LaunchOptions) Validate() error {
+	var errs []error
+	if o.Port <= 0 || o.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %d", o.Port))
+	}
+	if o.WSPath == "" {
+		errs = append(errs, errors.New("missing ws path"))
+	}
+	if _, err := o.headless(); err != nil {
+		errs = append(errs, err)
+	}
+	if !slices.Contains(operatingSystems, o.OS) {
+		errs = append(errs, fmt.Errorf("invalid os %q, expected one of %v", o.OS, operatingSystems))
+	}
+	if o.ScreenWidth <= 0 || o.ScreenHeight <= 0 {
+		errs = append(errs, fmt.Errorf("invalid screen size %dx%d", o.ScreenWidth, o.ScreenHeight))
+	}
+	if o.Proxy != nil && o.Proxy.Server == "" {
+		errs = append(errs, errors.New("proxy without server"))
+	}
+	return errors.Join(errs...)
+}
+
+// The websocket url the server listens on once started.
+func (o LaunchOptions) WSURL() string {
+	return fmt.Sprintf("ws://localhost:%d/%s", o.Port, strings.TrimPrefix(o.WSPath, "/"))
+}
+
+func (o LaunchOptions) headless() (any, error) {
+	switch o.Headless {
+	case "virtual":
+		return "virtual", nil
+	case "true", "false":
+		return o.Headless == "true", nil
+	default:
+		return nil, fmt.Errorf("invalid headless mode %q, expected virtual, true or false", o.Headless)
+	}
+}
+
+var scriptTemplate = template.Must(template.New("launch").Funcs(template.FuncMap{"py": pyLiteral}).Parse(`
+from camoufox.server import launch_server
+from browserforge.fingerprints import Screen
+
+launch_server(
+    screen=Screen(max_width={{py .ScreenWidth}}, max_height={{py .ScreenHeight}}),
+    headless={{py .Headless}},
+    os={{py .OS}},
+    config={{py .Config}},
+    block_images={{py .BlockImages}},
+    locale={{py .Locale}},
+    port={{py .Port}},
+    ws_path={{py .WSPath}},
+    i_know_what_im_doing=True,
+{{- if .Proxy}}
+    geoip={{py .GeoIP}},
+    proxy={{py .Proxy}},
+{{- end}}
+)
+`))
+
+// Renders the python script that starts the Camoufox server.
+// Every value is rendered as python literal, so it can't break out of the script.
+func (o LaunchOptions) Script() (string, error) {
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+	headless, _ := o.headless()
+
+	config := make(map[string]any, len(o.Config)+1)
+	for k, v := range o.Config {
+		config[k] = v
+	}
+	if o.UserAgent != "" {
+		config["navigator.userAgent"] = o.UserAgent
+	}
+
+	data := map[string]any{
+		"ScreenWidth":  o.ScreenWidth,
+		"ScreenHeight": o.ScreenHeight,
+		"Headless":     headless,
+		"OS":           o.OS,
+		"Config":       config,
+		"BlockImages":  o.BlockImages,
+		"Locale":       o.Locale,
+		"Port":         o.Port,
+		"WSPath":       o.WSPath,
+		"GeoIP":        o.GeoIP,
+		"Proxy":        nil,
+	}
+	if o.Proxy != nil {
+		data["Proxy"] = map[string]any{
+			"server":   o.Proxy.Server,
+			"username": o.Proxy.Username,
+			"password": o.Proxy.Password,
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := scriptTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render launch script: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Checks that the script is valid python syntax, without running it.
+func ValidateScript(script string) error {
+	cmd := exec.Command("python3", "-c", "import ast, sys; ast.parse(sys.stdin.read())")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("invalid launch script: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Returns a port that is currently free on localhost.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Renders a go value as python literal.
+func pyLiteral(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "None", nil
+	case bool:
+		if v {
+			return "True", nil
+		}
+		return "False", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		// a JSON string only uses escapes python understands as well
+		b, err := json.Marshal(v)
+		return string(b), err
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			lit, err := pyLiteral(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, lit)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // stable output
+		items := make([]string, 0, len(v))
+		for _, k := range keys {
+			key, _ := pyLiteral(k)
+			val, err := pyLiteral(v[k])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, key+": "+val)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	default:
+		return "", fmt.Errorf("unsupported python literal type %T", v)
+	}
+}
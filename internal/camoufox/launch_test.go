@@ -0,0 +1,123 @@
+package camoufox
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPyLiteral(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "None"},
+		{true, "True"},
+		{false, "False"},
+		{42, "42"},
+		{1.5, "1.5"},
+		{"en-US", `"en-US"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{[]any{"a", 1, false}, `["a", 1, False]`},
+		{map[string]any{"b": 1, "a": "x"}, `{"a": "x", "b": 1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			got, err := pyLiteral(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("got %s, want %s", got, test.expected)
+			}
+		})
+	}
+
+	if _, err := pyLiteral(struct{}{}); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := DefaultLaunchOptions()
+	valid.Port = 9222
+
+	tests := []struct {
+		name     string
+		modify   func(o *LaunchOptions)
+		hasError bool
+	}{
+		{"default", func(o *LaunchOptions) {}, false},
+		{"headless", func(o *LaunchOptions) { o.Headless = "true" }, false},
+		{"missing port", func(o *LaunchOptions) { o.Port = 0 }, true},
+		{"invalid headless", func(o *LaunchOptions) { o.Headless = "yes" }, true},
+		{"invalid os", func(o *LaunchOptions) { o.OS = "android" }, true},
+		{"invalid screen", func(o *LaunchOptions) { o.ScreenWidth = 0 }, true},
+		{"proxy without server", func(o *LaunchOptions) { o.Proxy = &Proxy{} }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := valid
+			test.modify(&opts)
+			err := opts.Validate()
+			if test.hasError != (err != nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestScript(t *testing.T) {
+	opts := DefaultLaunchOptions()
+	opts.Port = 9333
+	opts.UserAgent = `Mozilla/5.0 "quoted"`
+
+	script, err := opts.Script()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`headless="virtual",`,
+		`port=9333,`,
+		`"navigator.userAgent": "Mozilla/5.0 \"quoted\""`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script is missing %s:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "proxy=") || strings.Contains(script, "geoip=") {
+		t.Errorf("script without proxy must not set proxy or geoip:\n%s", script)
+	}
+	if opts.WSURL() != "ws://localhost:9333/play" {
+		t.Errorf("unexpected ws url %s", opts.WSURL())
+	}
+
+	opts.Headless = "false"
+	opts.Proxy = &Proxy{Server: "http://1.2.3.4:8080", Username: "user", Password: `pa"ss`}
+	script, err = opts.Script()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`headless=False,`,
+		`geoip=True,`,
+		`proxy={"password": "pa\"ss", "server": "http://1.2.3.4:8080", "username": "user"},`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script is missing %s:\n%s", want, script)
+		}
+	}
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	if err := ValidateScript(script); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateScript("launch_server(\n"); err == nil {
+		t.Error("expected error for invalid script")
+	}
+}
@@ -0,0 +1,143 @@
+package canonical
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"golang.org/x/net/publicsuffix"
+)
+
+// The host of the default marketplace. Urls of other marketplaces, e.g. amazon.de,
+// are normalized to the www host of their marketplace.
+const Host = "www.amazon.com"
+
+// Query parameters that change the content of search and category pages,
+// all other parameters are tracking or session state.
+var AllowedParams = map[string]bool{
+	"rnid":           true,
+	"node":           true,
+	"bbn":            true,
+	"keywords":       true,
+	"k":              true,
+	"c":              true,
+	"i":              true,
+	"page":           true,
+	"rh":             true, // filters items by their attributes, normalized by FilterQuery
+	"sprefix":        true,
+	"search-alias":   true,
+	"field-author":   true,
+	"field-keywords": true,
+	"text":           true,
+	// "language": true
+}
+
+// Removes the query parameters not in AllowedParams, "ref=" path segments
+// and empty path segments, including the trailing slash.
+// The remaining parameters are sorted by their name.
+func FilterQuery(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	q := parsedURL.Query()
+	filtered := url.Values{}
+	for key, val := range q {
+		key = strings.ToLower(key)
+		if !AllowedParams[key] {
+			continue
+		}
+		if key == "rh" {
+			for i, rh := range val {
+				val[i] = ParseRefinements(rh).String()
+			}
+		}
+		filtered[key] = append(filtered[key], val...)
+	}
+	parsedURL.RawQuery = filtered.Encode()
+
+	// clean "ref=..." path
+	segments := strings.Split(parsedURL.Path, "/")
+	var cleanSegments []string
+	for _, s := range segments {
+		if s != "" && !strings.HasPrefix(s, "ref=") && !strings.HasPrefix(s, "ref-") {
+			cleanSegments = append(cleanSegments, s)
+		}
+	}
+	parsedURL.Path = "/" + strings.Join(cleanSegments, "/")
+
+	return parsedURL.String()
+}
+
+// Normalizes the url, so every url of the same page is equal.
+// Amazon urls use https and the www host of their marketplace, product urls of all forms, e.g. with
+// a slug or /gp/product/, collapse to /dp/{asin}. Other hosts, e.g. a local
+// test server, keep their scheme.
+func URL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("url without host")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	if marketplace, ok := marketplaceOf(host); ok {
+		u.Scheme = "https"
+		host = marketplace
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+	u.User = nil
+	u.Fragment = ""
+
+	if asin, err := internal.AsinFromURL(u.EscapedPath()); err == nil {
+		return u.Scheme + "://" + u.Host + "/dp/" + asin, nil
+	}
+	return FilterQuery(u.String()), nil
+}
+
+// Returns the key identifying the page of the url:
+// "asin:{host}:{asin}" for products, the canonical url otherwise.
+// The host is part of the key, as every marketplace has its own page of a product.
+func Key(rawURL string) (string, error) {
+	canonical, err := URL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if asin, err := internal.AsinFromURL(canonical); err == nil {
+		u, err := url.Parse(canonical)
+		if err != nil {
+			return "", err
+		}
+		return "asin:" + u.Host + ":" + asin, nil
+	}
+	return canonical, nil
+}
+
+// Returns the www host of the Amazon marketplace the host belongs to,
+// e.g. www.amazon.co.uk for smile.amazon.co.uk.
+// Only the storefront hosts are marketplaces, other subdomains like
+// sellercentral.amazon.com or aws.amazon.com are separate sites.
+func marketplaceOf(host string) (string, bool) {
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil || !strings.HasPrefix(site, "amazon.") {
+		return "", false
+	}
+	switch strings.TrimSuffix(host, site) {
+	case "", "www.", "smile.", "m.":
+		return "www." + site, true
+	}
+	return "", false
+}
@@ -0,0 +1,156 @@
+package canonical
+
+import "testing"
+
+func TestFilterQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Removes /ref=... in path and keeps allowed param",
+			input:    "https://amazon.com/b/ref=SHCC/?node=23528055011",
+			expected: "https://amazon.com/b?node=23528055011",
+		},
+		{
+			name:     "Keeps allowed query param, strips ref from path",
+			input:    "https://amazon.com/some/ref=abc123/path?node=123&bad=1",
+			expected: "https://amazon.com/some/path?node=123",
+		},
+		{
+			name:     "Removes /ref=... with allowed 'k' param",
+			input:    "https://amazon.com/search/ref=something?k=headphones&foo=bar",
+			expected: "https://amazon.com/search?k=headphones",
+		},
+		{
+			name:     "Removes trailing /ref=... with no query params",
+			input:    "https://amazon.com/b/ref=SHCC/",
+			expected: "https://amazon.com/b",
+		},
+		{
+			name:     "Keeps multiple allowed query params",
+			input:    "https://amazon.com/s?node=123&k=ipad&junk=1",
+			expected: "https://amazon.com/s?k=ipad&node=123",
+		},
+		{
+			name:     "No path ref, no query params",
+			input:    "https://amazon.com/dp/B08N5WRWNW",
+			expected: "https://amazon.com/dp/B08N5WRWNW",
+		},
+		{
+			name:     "Removes /ref-",
+			input:    "https://amazon.com/ref-GC_AGCLP_Congrats_SUB/s/?bbn=2973109011&i=gift-cards",
+			expected: "https://amazon.com/s?bbn=2973109011&i=gift-cards",
+		},
+		{
+			name:     "Empty input string",
+			input:    "",
+			expected: "/",
+		},
+		{
+			name:     "Normalizes refinements",
+			input:    "https://amazon.com/s?rh=p_89%3AMega+Bloks%7CLEGO%2Cn%3A165793011&k=blocks",
+			expected: "https://amazon.com/s?k=blocks&rh=n%3A165793011%2Cp_89%3ALEGO%7CMega+Bloks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FilterQuery(tt.input)
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://amazon.com/s?k=lego", "https://www.amazon.com/s?k=lego"},
+		{"HTTP://WWW.Amazon.COM:443/s/?page=2&K=lego&ref_=nav", "https://www.amazon.com/s?k=lego&page=2"},
+		{"https://www.amazon.com/b/?node=3375251#main", "https://www.amazon.com/b?node=3375251"},
+		{"https://smile.amazon.com/b?node=1", "https://www.amazon.com/b?node=1"},
+		{"http://amazon.de/s?k=lego", "https://www.amazon.de/s?k=lego"},
+		{"https://smile.amazon.co.uk/gp/product/B00NHQFA1I", "https://www.amazon.co.uk/dp/B00NHQFA1I"},
+		{"https://m.media-amazon.com/images/I/a.jpg", "https://m.media-amazon.com/images/I/a.jpg"},
+		{"http://m.amazon.de/s?k=lego", "https://www.amazon.de/s?k=lego"},
+		{"https://sellercentral.amazon.com/home", "https://sellercentral.amazon.com/home"},
+		{"https://aws.amazon.com/s3/", "https://aws.amazon.com/s3"},
+		{"http://console.aws.amazon.com:80/", "http://console.aws.amazon.com/"},
+		{"https://www.amazon.com/LEGO-Classic-Creative/dp/B00NHQFA1I/ref=sr_1_1?keywords=lego&psc=1", "https://www.amazon.com/dp/B00NHQFA1I"},
+		{"https://www.amazon.com/gp/product/B00NHQFA1I/", "https://www.amazon.com/dp/B00NHQFA1I"},
+		{"https://www.amazon.com/dp/B00NHQFA1I?th=1", "https://www.amazon.com/dp/B00NHQFA1I"},
+		{"http://Localhost:8080/dp/B00NHQFA1I/", "http://localhost:8080/dp/B00NHQFA1I"},
+		{"http://localhost:80/s?k=lego", "http://localhost/s?k=lego"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := URL(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("got %q, want %q", got, test.expected)
+			}
+		})
+	}
+
+	if _, err := URL("/s?k=lego"); err == nil {
+		t.Error("expected error for url without host")
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://amazon.com/Some-Slug/dp/B00NHQFA1I/ref=x", "asin:www.amazon.com:B00NHQFA1I"},
+		{"https://www.amazon.com/gp/product/B00NHQFA1I", "asin:www.amazon.com:B00NHQFA1I"},
+		{"https://www.amazon.de/dp/B00NHQFA1I", "asin:www.amazon.de:B00NHQFA1I"},
+		{"https://www.amazon.com/s?page=2&k=lego", "https://www.amazon.com/s?k=lego&page=2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := Key(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("got %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRefinements(t *testing.T) {
+	tests := []struct {
+		rh       string
+		expected string
+	}{
+		{"n:165793011,p_89:LEGO", "n:165793011,p_89:LEGO"},
+		{"p_89:Mega Bloks|LEGO,n:165793011", "n:165793011,p_89:LEGO|Mega Bloks"},
+		{"p_89:LEGO|LEGO, p_36:1000-5000", "p_36:1000-5000,p_89:LEGO"},
+		{"n:,broken,:1", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := ParseRefinements(test.rh).String(); result != test.expected {
+			t.Errorf("ParseRefinements(%q).String() = %q; want %q", test.rh, result, test.expected)
+		}
+	}
+}
+
+func TestWithoutRefinements(t *testing.T) {
+	result := WithoutRefinements("https://www.amazon.com/s?k=lego&rh=p_89%3ALEGO")
+	if result != "https://www.amazon.com/s?k=lego" {
+		t.Errorf("WithoutRefinements() = %q", result)
+	}
+}
@@ -0,0 +1,61 @@
+package canonical
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// The filters of a search, from the "rh" parameter, e.g. "n:165793011,p_89:LEGO|Mega Bloks".
+// Keys are the refined attributes, e.g. n for the browse node, p_89 for the brand or p_36 for the price.
+type Refinements map[string][]string
+
+func ParseRefinements(rh string) Refinements {
+	r := make(Refinements)
+	for _, refinement := range strings.Split(rh, ",") {
+		key, values, ok := strings.Cut(strings.TrimSpace(refinement), ":")
+		if !ok || key == "" || values == "" {
+			continue
+		}
+		for _, v := range strings.Split(values, "|") {
+			if v != "" && !slices.Contains(r[key], v) {
+				r[key] = append(r[key], v)
+			}
+		}
+	}
+	return r
+}
+
+// Formats the refinements with sorted keys and values, so equal refinements are written the same.
+func (r Refinements) String() string {
+	keys := make([]string, 0, len(r))
+	for key, values := range r {
+		if len(values) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := slices.Clone(r[key])
+		slices.Sort(values)
+		parts = append(parts, key+":"+strings.Join(slices.Compact(values), "|"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns the url without the refinements of the search.
+func WithoutRefinements(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	if !q.Has("rh") {
+		return rawURL
+	}
+	q.Del("rh")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"os"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -16,22 +20,116 @@ func (l LogLevel) ToSlog() slog.Level {
 }
 
 type Config struct {
-	OpensearchAddresses []string      `env:"OPENSEARCH_ADDRESSES"`
-	OpensearchUsername  string        `env:"OPENSEARCH_USERNAME"`
-	OpensearchPassword  string        `env:"OPENSEARCH_PASSWORD"`
-	PostgresURL         string        `env:"POSTGRES_URL" env-required:"true"`
-	PollInterval        time.Duration `env:"POLL_INTERVAL" env-default:"6s" env-required:"true"`
-	Proxy               string        `env:"PROXY"`
-	ProxyPW             string        `env:"PROXY_PASSWORD"`
-	ProxyUser           string        `env:"PROXY_USERNAME"`
-	SeedURLs            []string      `env:"SEED_URLS"`
-	PlaywrightDriverDir string        `env:"PLAYWRIGHT_DRIVER_DIR"`
-	LogLevel            LogLevel      `env:"LOG_LEVEL"`
+	OpensearchAddresses []string           `env:"OPENSEARCH_ADDRESSES"`
+	OpensearchUsername  string             `env:"OPENSEARCH_USERNAME"`
+	OpensearchPassword  string             `env:"OPENSEARCH_PASSWORD"`
+	PostgresURL         string             `env:"POSTGRES_URL" env-required:"true"`
+	PollInterval        time.Duration      `env:"POLL_INTERVAL" env-default:"6s" env-required:"true"`
+	Proxy               string             `env:"PROXY"`
+	ProxyPW             string             `env:"PROXY_PASSWORD"`
+	ProxyUser           string             `env:"PROXY_USERNAME"`
+	SeedURLs            []string           `env:"SEED_URLS"`
+	PlaywrightDriverDir string             `env:"PLAYWRIGHT_DRIVER_DIR"`
+	LogLevel            LogLevel           `env:"LOG_LEVEL"`
+	InstanceID          string             `env:"INSTANCE_ID"`
+	LeaseDuration       time.Duration      `env:"LEASE_DURATION" env-default:"2m"`
+	HeartbeatInterval   time.Duration      `env:"HEARTBEAT_INTERVAL" env-default:"15s"`
+	InstanceTimeout     time.Duration      `env:"INSTANCE_TIMEOUT" env-default:"1m"`
+	DrainTimeout        time.Duration      `env:"DRAIN_TIMEOUT" env-default:"30s"`
+	RetryPolicies       []string           `env:"RETRY_POLICIES"` // e.g. captcha=cooldown:3:30m,http_4xx=none
+	BreakerWindow       time.Duration      `env:"BREAKER_WINDOW" env-default:"5m"`
+	BreakerMinRequests  int                `env:"BREAKER_MIN_REQUESTS" env-default:"20"`
+	BreakerThreshold    float64            `env:"BREAKER_THRESHOLD" env-default:"0.5"`
+	BreakerThresholds   map[string]float64 `env:"BREAKER_CLASS_THRESHOLDS"` // e.g. captcha:0.1,timeout:0.7
+	BreakerOpenDuration time.Duration      `env:"BREAKER_OPEN_DURATION" env-default:"2m"`
+	BreakerProbes       int                `env:"BREAKER_PROBES" env-default:"3"`
+	BlockCoolDown       time.Duration      `env:"BLOCK_COOLDOWN" env-default:"1m"`
+	RestartOnBlock      bool               `env:"BLOCK_RESTART_BROWSER" env-default:"false"`
+	Proxies             []string           `env:"PROXIES"`                                  // host:port[:user:pass] or scheme://[user:pass@]host:port
+	ProxyFile           string             `env:"PROXY_FILE"`                               // one proxy per line
+	ProxyStrategy       string             `env:"PROXY_STRATEGY" env-default:"round-robin"` // round-robin, random, lru or sticky
+	ProxyMaxFailures    int                `env:"PROXY_MAX_FAILURES" env-default:"5"`       // consecutive failures that quarantine a proxy
+	ProxyMinScore       float64            `env:"PROXY_MIN_SCORE" env-default:"0.3"`        // health score below which a proxy is quarantined
+	ProxyQuarantine     time.Duration      `env:"PROXY_QUARANTINE" env-default:"5m"`        // doubled on each quarantine
+	ProxyQuarantineMax  time.Duration      `env:"PROXY_QUARANTINE_MAX" env-default:"6h"`
+	ProxyHealthURL      string             `env:"PROXY_HEALTH_URL"`
+	ProxyHealthInterval time.Duration      `env:"PROXY_HEALTH_INTERVAL" env-default:"1m"`
+	StatusAddr          string             `env:"STATUS_ADDR"`                           // e.g. :8080, serves crawler statistics as JSON
+	MaxPagesPerBrowser  int                `env:"BROWSER_MAX_PAGES" env-default:"0"`     // restart the browser after this many pages
+	MaxBrowserMemoryMB  int                `env:"BROWSER_MAX_MEMORY_MB" env-default:"0"` // restart the browser above this memory usage
+	MaxRestarts         int                `env:"BROWSER_MAX_RESTARTS" env-default:"5"`  // browser restarts allowed within the restart window
+	RestartWindow       time.Duration      `env:"BROWSER_RESTART_WINDOW" env-default:"10m"`
+	CamoufoxPort        int                `env:"CAMOUFOX_PORT" env-default:"0"` // 0 picks a free port
+	CamoufoxWSPath      string             `env:"CAMOUFOX_WS_PATH" env-default:"play"`
+	CamoufoxHeadless    string             `env:"CAMOUFOX_HEADLESS" env-default:"virtual"` // virtual, true or false
+	CamoufoxOS          string             `env:"CAMOUFOX_OS" env-default:"windows"`       // windows, macos or linux
+	CamoufoxLocale      string             `env:"CAMOUFOX_LOCALE" env-default:"en-US"`
+	CamoufoxScreenW     int                `env:"CAMOUFOX_SCREEN_WIDTH" env-default:"1920"`
+	CamoufoxScreenH     int                `env:"CAMOUFOX_SCREEN_HEIGHT" env-default:"1080"`
+	CamoufoxBlockImages bool               `env:"CAMOUFOX_BLOCK_IMAGES" env-default:"true"`
+	CamoufoxGeoIP       bool               `env:"CAMOUFOX_GEOIP" env-default:"true"`
+	CamoufoxConfig      string             `env:"CAMOUFOX_CONFIG"`                     // JSON object of additional Camoufox config properties
+	BrowserMode         string             `env:"BROWSER_MODE" env-default:"camoufox"` // camoufox, firefox, chromium or remote
+	BrowserHeadless     bool               `env:"BROWSER_HEADLESS" env-default:"true"` // only used by the firefox and chromium modes
+	BrowserWSEndpoint   string             `env:"BROWSER_WS_ENDPOINT"`                 // websocket url of the remote browser server
+	BrowserRemoteEngine string             `env:"BROWSER_REMOTE_ENGINE" env-default:"firefox"`
+	SessionPoolSize     int                `env:"SESSION_POOL_SIZE" env-default:"10"`                     // browser contexts kept alive and reused
+	SessionMaxAge       time.Duration      `env:"SESSION_MAX_AGE" env-default:"30m"`                      // sessions are retired once they are older
+	SessionMaxRequests  int                `env:"SESSION_MAX_REQUESTS" env-default:"100"`                 // 1 uses a fresh context per url
+	SessionDir          string             `env:"SESSION_DIR"`                                            // saves cookies and local storage of sessions across restarts
+	Locations           []string           `env:"LOCATIONS"`                                              // delivery ZIP codes, e.g. 10001,94103
+	MiddlewareFile      string             `env:"REQUEST_MIDDLEWARE_FILE"`                                // yaml or json file with headers, cookies, geolocation and timezone to set
+	InterceptRulesFile  string             `env:"INTERCEPT_RULES_FILE"`                                   // yaml or json file with request interception rules, replaces the default rules
+	Tombstones          bool               `env:"EMIT_TOMBSTONES" env-default:"false"`                    // delist products whose page was removed
+	MaxDepth            int                `env:"MAX_DEPTH" env-default:"0"`                              // links further from their seed are not queued, 0 disables
+	Frontier            string             `env:"FRONTIER" env-default:"fifo"`                            // order of leasing queued urls: fifo, bfs, dfs, product_first, random or best_first
+	Recrawl             bool               `env:"RECRAWL" env-default:"false"`                            // revisit done product pages
+	RecrawlMinInterval  time.Duration      `env:"RECRAWL_MIN_INTERVAL" env-default:"6h"`                  // products are never revisited sooner
+	RecrawlMaxInterval  time.Duration      `env:"RECRAWL_MAX_INTERVAL" env-default:"720h"`                // products are revisited at the latest after this long
+	RecrawlCategories   []string           `env:"RECRAWL_CATEGORY_INTERVALS"`                             // fixed revisit intervals, e.g. Baby=24h,Toys & Games=48h
+	RecrawlCheck        time.Duration      `env:"RECRAWL_CHECK_INTERVAL" env-default:"5m"`                // how often due revisits are queued
+	BudgetProducts      int64              `env:"BUDGET_PRODUCTS" env-default:"0"`                        // consumed products, 0 is unlimited
+	BudgetPages         int64              `env:"BUDGET_PAGES" env-default:"0"`                           // fetched pages, 0 is unlimited
+	BudgetBytes         int64              `env:"BUDGET_BYTES" env-default:"0"`                           // received bytes, 0 is unlimited
+	BudgetDuration      time.Duration      `env:"BUDGET_DURATION" env-default:"0"`                        // wall-clock time since the run started, 0 is unlimited
+	BudgetAction        string             `env:"BUDGET_ACTION" env-default:"stop"`                       // stop or discovery, what happens once a budget is reached
+	BudgetRun           string             `env:"BUDGET_RUN" env-default:"default"`                       // budgets are counted per run, change it to start a new bounded crawl
+	CategoryQuota       int64              `env:"QUOTA_PER_CATEGORY" env-default:"0"`                     // consumed products per top level category, 0 is unlimited
+	SeedQuota           int64              `env:"QUOTA_PER_SEED" env-default:"0"`                         // consumed products per seed, 0 is unlimited
+	FacetExpansion      bool               `env:"FACET_EXPANSION" env-default:"false"`                    // split searches with more results than Amazon shows
+	FacetMaxPages       int                `env:"FACET_MAX_PAGES" env-default:"7"`                        // result pages Amazon shows per search
+	FacetDimensions     []string           `env:"FACET_DIMENSIONS" env-default:"node,brand,price,rating"` // tried in order to split a search
+	FacetMinPriceSpan   int                `env:"FACET_MIN_PRICE_SPAN" env-default:"100"`                 // price ranges in cents aren't split further
+	TrapDetection       bool               `env:"TRAP_DETECTION" env-default:"false"`                     // stop following url patterns that lead nowhere
+	TrapMaxURLs         int                `env:"TRAP_MAX_URLS" env-default:"50000"`                      // urls one pattern may add to the queue, 0 is unlimited
+	TrapMinPages        int                `env:"TRAP_MIN_PAGES" env-default:"50"`                        // consecutive pages of a pattern without new products before it's suppressed
+	TrapMaxTemplates    int                `env:"TRAP_MAX_TEMPLATES" env-default:"100000"`                // patterns tracked, the least recently seen are forgotten first, 0 is unlimited
+	TrapIdleTimeout     time.Duration      `env:"TRAP_IDLE_TIMEOUT" env-default:"6h"`                     // patterns not seen for this long are forgotten, 0 keeps them
+	Sitemaps            []string           `env:"SITEMAPS"`                                               // sitemaps and sitemap indexes whose urls are queued
+	SitemapsFromRobots  bool               `env:"SITEMAPS_FROM_ROBOTS" env-default:"false"`               // also queue the urls of the sitemaps in robots.txt
+	KeywordFile         string             `env:"KEYWORD_FILE"`                                           // yaml or json file with keywords to search, see seed.Keyword
+	Marketplaces        []string           `env:"MARKETPLACES" env-default:"www.amazon.com"`              // hosts the keywords are searched on
 }
 
 func LoadConfig() (Config, error) {
 	var cfg Config
 	cleanenv.ReadConfig(".env", &cfg)
 	err := cleanenv.ReadEnv(&cfg)
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = defaultInstanceID()
+	}
 	return cfg, err
 }
+
+// Hostname and pid tell replicas apart, also when they share a host. A restarted container
+// keeps its hostname and often its pid, so a random suffix keeps it from taking over
+// the leases of its previous process.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
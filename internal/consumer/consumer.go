@@ -8,5 +8,9 @@ import (
 
 type Consumer interface {
 	Consume(ctx context.Context, prd internal.Product) error
+	// Removes all products of the tombstone's ASIN.
+	Delist(ctx context.Context, t internal.Tombstone) error
+	// Writes all buffered products.
+	Flush(ctx context.Context) error
 	Close()
 }
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/canonical"
 	"github.com/opensearch-project/opensearch-go/v4"
 	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
 )
@@ -58,6 +59,8 @@ type osconsumer struct {
 	log    *slog.Logger
 	buffer []internal.Product
 	mu     sync.Mutex
+	stop   chan struct{} // stops the flush thread
+	done   chan struct{} // closed once the flush thread exited
 }
 
 func NewOpensearchConsumer(opts ...OpensearchConsumerOption) (Consumer, error) {
@@ -85,6 +88,8 @@ func NewOpensearchConsumer(opts ...OpensearchConsumerOption) (Consumer, error) {
 	c := &osconsumer{
 		client: client,
 		log:    logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
 	err = c.createIndex(ctx)
 	if err != nil {
@@ -107,15 +112,72 @@ func (o *osconsumer) Consume(ctx context.Context, prd internal.Product) error {
 	o.mu.Unlock()
 
 	if shouldFlush {
-		o.flush(ctx)
+		if err := o.flush(ctx); err != nil {
+			o.log.Error("failed to flush products", internal.ErrAttr(err))
+		}
+	}
+
+	return nil
+}
+
+// Deletes the documents of the ASIN in every location of the tombstone's marketplace.
+func (o *osconsumer) Delist(ctx context.Context, t internal.Tombstone) error {
+	// buffered documents of the ASIN would be indexed again after the delete
+	if err := o.flush(ctx); err != nil {
+		return err
 	}
 
+	query, err := json.Marshal(map[string]any{
+		"query": delistQuery(t),
+	})
+	if err != nil {
+		return err
+	}
+	res, err := o.client.Document.DeleteByQuery(ctx, opensearchapi.DocumentDeleteByQueryReq{
+		Indices: []string{indexName},
+		Body:    bytes.NewReader(query),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delist %s: %w", t.ASIN, err)
+	}
+	o.log.Info("delisted product", slog.String("asin", t.ASIN), slog.String("marketplace", t.Marketplace), slog.Int("deleted", res.Deleted))
 	return nil
 }
 
+func delistQuery(t internal.Tombstone) map[string]any {
+	filter := []any{
+		map[string]any{"term": map[string]string{"asin": t.ASIN}},
+	}
+	if t.Marketplace != "" {
+		marketplace := []any{
+			map[string]any{"term": map[string]string{"marketplace": t.Marketplace}},
+		}
+		if t.Marketplace == canonical.Host {
+			// documents indexed before marketplaces were recorded are of the default marketplace
+			marketplace = append(marketplace, map[string]any{
+				"bool": map[string]any{"must_not": map[string]any{"exists": map[string]string{"field": "marketplace"}}},
+			})
+		}
+		filter = append(filter, map[string]any{
+			"bool": map[string]any{"should": marketplace, "minimum_should_match": 1},
+		})
+	}
+	return map[string]any{"bool": map[string]any{"filter": filter}}
+}
+
+func (o *osconsumer) Flush(ctx context.Context) error {
+	return o.flush(ctx)
+}
+
 func (o *osconsumer) Close() {
+	// stop the flush thread first so it can't race with the final flush
+	close(o.stop)
+	<-o.done
+
 	o.log.Info("closing consumer, flushing buffered posts")
-	o.flush(context.Background())
+	if err := o.flush(context.Background()); err != nil {
+		o.log.Error("failed to flush products", internal.ErrAttr(err))
+	}
 }
 
 func (o *osconsumer) createIndex(ctx context.Context) error {
@@ -152,6 +214,9 @@ func (o *osconsumer) createIndex(ctx context.Context) error {
                     "sellerId":                 { "type": "keyword" },
                     "firstAvailableAt":         { "type": "date" },
                     "boughtPastMonth":          { "type": "integer" },
+                    "location":                 { "type": "keyword" },
+                    "keyword":                  { "type": "keyword" },
+                    "marketplace":              { "type": "keyword" },
                     "bestSellers": {
                         "properties" : {
                             "category":         { "type": "keyword" },
@@ -169,18 +234,18 @@ func (o *osconsumer) createIndex(ctx context.Context) error {
 	return nil
 }
 
-func (o *osconsumer) flush(ctx context.Context) {
+func (o *osconsumer) flush(ctx context.Context) error {
 	o.mu.Lock()
 	if len(o.buffer) == 0 {
 		o.mu.Unlock()
-		return
+		return nil
 	}
 
 	postsLen := len(o.buffer)
 	actions := make([]string, 0, postsLen*2)
 	for _, products := range o.buffer {
 		meta := map[string]map[string]string{
-			"index": {"_index": indexName, "_id": products.ASIN},
+			"index": {"_index": indexName, "_id": documentID(products)},
 		}
 		metaLine, err := json.Marshal(meta)
 		if err != nil {
@@ -206,19 +271,43 @@ func (o *osconsumer) flush(ctx context.Context) {
 		Body: &buf,
 	})
 	if err != nil {
-		o.log.Error("failed to perform bulk request", internal.ErrAttr(err))
+		return fmt.Errorf("failed to perform bulk request: %w", err)
 	}
 	if bulkRes.Errors {
 		o.log.Warn("got error items on bulk request")
 	}
+	return nil
 }
+
 func (o *osconsumer) startFlushThread() {
 	interval := time.Second * flushIntervalSecs
 	o.log.Info(fmt.Sprintf("flushing every %s", interval))
 	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
-			o.flush(context.Background())
+		defer close(o.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+				if err := o.flush(context.Background()); err != nil {
+					o.log.Error("failed to flush products", internal.ErrAttr(err))
+				}
+			}
 		}
 	}()
 }
+
+// Products are stored once per marketplace and delivery location, so they can be compared across regions.
+// Products of the default marketplace keep the ids they had before marketplaces were recorded.
+func documentID(p internal.Product) string {
+	id := p.ASIN
+	if p.Marketplace != "" && p.Marketplace != canonical.Host {
+		id += "-" + p.Marketplace
+	}
+	if p.Location != "" {
+		id += "-" + p.Location
+	}
+	return id
+}
@@ -21,4 +21,14 @@ func (s *stdoutConsumer) Consume(ctx context.Context, prd internal.Product) erro
 	return nil
 }
 
+func (s *stdoutConsumer) Delist(ctx context.Context, t internal.Tombstone) error {
+	marshalled, _ := json.Marshal(map[string]internal.Tombstone{"tombstone": t})
+	fmt.Println(string(marshalled))
+	return nil
+}
+
+func (s *stdoutConsumer) Flush(ctx context.Context) error {
+	return nil
+}
+
 func (s *stdoutConsumer) Close() {}
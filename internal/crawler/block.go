@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// Reports whether errors of the class mean Amazon blocked the identity,
+// rather than the url being faulty.
+func isBlock(class crawlerr.Class) bool {
+	return class == crawlerr.Captcha
+}
+
+// Marks the url as blocked, so it is retried on another identity after the cool-down,
+// pauses fetching and retires the identity. Optionally the browser is restarted as well.
+func (c *crawler) onBlocked(ctx context.Context, job storage.QueuedURL, id *identity, err error) {
+	class := crawlerr.ClassOf(err)
+	blocks := id.blocks.Add(1)
+	c.log.Warn(err.Error(),
+		slog.String("url", job.URL),
+		slog.String("identity", id.id),
+		slog.Int64("blocks", blocks),
+		slog.Int64("requests", id.requests.Load()),
+	)
+
+	if err := c.Storage.MarkBlocked(ctx, job.URL, c.failure(job, class, err, id)); err != nil {
+		c.log.Error(err.Error())
+	}
+	c.breaker.Failure(class)
+	c.coolDown(c.BlockCoolDown)
+
+	if c.RestartOnBlock {
+		c.restartBrowser(id, "identity blocked", true)
+		return
+	}
+	c.rotateIdentity(id)
+}
+
+// Describes why the job failed and schedules its retry by the policy of the error class.
+func (c *crawler) failure(job storage.QueuedURL, class crawlerr.Class, err error, id *identity) storage.Failure {
+	policy := c.RetryPolicies.Get(class)
+	f := storage.Failure{
+		Class:  string(class),
+		Reason: err.Error(),
+	}
+	// only blocked identities are retired, avoiding any other identity could starve the url
+	if policy.Action == crawlerr.CoolDown && isBlock(class) {
+		f.AvoidIdentity = id.id
+	}
+	if retryAt, ok := policy.NextRetry(job.RetryCount+1, time.Now()); ok {
+		f.RetryAt = &retryAt
+	}
+	return f
+}
+
+// Pauses leasing new urls for d, extending a running cool-down if needed.
+func (c *crawler) coolDown(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	for {
+		current := c.coolDownUntil.Load()
+		if current >= until || c.coolDownUntil.CompareAndSwap(current, until) {
+			return
+		}
+	}
+}
+
+// Blocks until the cool-down is over or ctx is done.
+func (c *crawler) waitCoolDown(ctx context.Context) error {
+	remaining := time.Until(time.Unix(0, c.coolDownUntil.Load()))
+	if remaining <= 0 {
+		return nil
+	}
+
+	c.log.Info(fmt.Sprintf("cooling down for %s", remaining.Round(time.Second)))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(remaining):
+		return nil
+	}
+}
+
+// Retires the identity without restarting the browser. Its sessions are discarded and
+// the following urls are leased under a new identity with a fresh proxy and User-Agent.
+// Waits for the jobs using the current sessions to finish first.
+func (c *crawler) rotateIdentity(old *identity) {
+	c.browserMu.Lock()
+	defer c.browserMu.Unlock()
+
+	if c.identity != old {
+		return // already rotated or restarted
+	}
+
+	id := c.newIdentity()
+	id.userAgent = old.userAgent
+	if r, ok := c.Launcher.(userAgentRotator); ok {
+		id.userAgent = r.randomUserAgent()
+	}
+	c.log.Info("rotating identity", slog.String("identity", old.id), slog.String("new", id.id), slog.String("userAgent", id.userAgent))
+	c.retireIdentity(old)
+	c.retireSessions("identity blocked")
+	c.identity = id
+}
@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/budget"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+	"github.com/playwright-community/playwright-go"
+)
+
+// Returned for products whose category or seed consumed its quota, they aren't consumed.
+var errOverQuota = errors.New("quota reached")
+
+// Continues the budget of the run where the previous start stopped,
+// or starts the run if this is the first instance crawling it.
+func (c *crawler) restoreBudget(ctx context.Context) error {
+	if c.Budget == nil {
+		return nil
+	}
+	run := c.Budget.Limits().Run
+	startedAt, err := c.Storage.StartBudgetRun(ctx, run)
+	if err != nil {
+		return err
+	}
+	c.budgetStartedAt = startedAt
+	usage, err := c.Storage.BudgetUsage(ctx, run)
+	if err != nil {
+		return err
+	}
+	usage[budget.Seconds] = int64(time.Since(startedAt).Seconds())
+	c.Budget.Set(usage)
+	c.checkBudget()
+	return nil
+}
+
+// Adds the usage to the budget counters shared by all instances and enforces the budget.
+func (c *crawler) recordUsage(ctx context.Context, usage budget.Usage) {
+	if c.Budget == nil {
+		return
+	}
+	totals, err := c.Storage.AddBudgetUsage(ctx, c.Budget.Limits().Run, usage)
+	if err != nil {
+		c.log.Error(err.Error())
+		return
+	}
+	c.Budget.Set(totals)
+	c.checkBudget()
+}
+
+func (c *crawler) checkBudget() {
+	name, ok := c.Budget.Exhausted()
+	if !ok || !c.budgetExhausted.CompareAndSwap(false, true) {
+		return
+	}
+	switch c.Budget.Limits().Action {
+	case budget.Stop:
+		c.log.Info("budget exhausted, stopping crawler", slog.String("budget", name))
+		c.Cancel()
+	case budget.Discovery:
+		c.log.Info("budget exhausted, no new urls are queued", slog.String("budget", name))
+	}
+}
+
+// Records the consumed product against the budget and the quotas of its top level category and seed.
+func (c *crawler) recordProduct(ctx context.Context, product internal.Product, seed string) {
+	usage := budget.Usage{budget.Products: 1}
+	if len(product.Categories) > 0 {
+		usage[budget.CategoryKey(product.Categories[0])] = 1
+	}
+	if seed != "" {
+		usage[budget.SeedKey(seed)] = 1
+	}
+	c.recordUsage(ctx, usage)
+}
+
+// Drops all links once the budget is exhausted, and the links of seeds that consumed their quota.
+func (c *crawler) withinBudget(links []storage.Link) []storage.Link {
+	if c.Budget == nil {
+		return links
+	}
+	if _, ok := c.Budget.Exhausted(); ok {
+		return nil
+	}
+	return slices.DeleteFunc(links, func(l storage.Link) bool {
+		return c.Budget.SeedFull(l.Seed)
+	})
+}
+
+// Periodically updates the time passed since the run started.
+// All instances compare against the same persisted start, so the time isn't summed over them.
+func (c *crawler) startBudgetClock() {
+	if c.Budget == nil {
+		return
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.workCtx.Done():
+				return
+			case now := <-ticker.C:
+				c.Budget.Set(budget.Usage{budget.Seconds: int64(now.Sub(c.budgetStartedAt).Seconds())})
+				c.checkBudget()
+			}
+		}
+	}()
+}
+
+// Sums the bytes received by all requests of a page.
+type byteMeter struct {
+	mu       sync.Mutex
+	pending  sync.WaitGroup
+	stopped  bool
+	received atomic.Int64
+}
+
+func meterBytes(page playwright.Page) *byteMeter {
+	m := &byteMeter{}
+	page.OnRequestFinished(func(r playwright.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.stopped {
+			return
+		}
+		// the sizes are requested from the browser, which mustn't block the event loop
+		m.pending.Add(1)
+		go func() {
+			defer m.pending.Done()
+			sizes, err := r.Sizes()
+			if err != nil {
+				return
+			}
+			m.received.Add(int64(sizes.ResponseHeadersSize + sizes.ResponseBodySize))
+		}()
+	})
+	return m
+}
+
+// Stops metering and returns the received bytes, once the sizes of the finished requests are known.
+// must be called before the page is closed
+func (m *byteMeter) total() int64 {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+	m.pending.Wait()
+	return m.received.Load()
+}
@@ -0,0 +1,96 @@
+package classify
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type PageType string
+
+const (
+	Unknown    PageType = "unknown"
+	Product    PageType = "product"
+	Search     PageType = "search"
+	Category   PageType = "category"
+	Bestseller PageType = "bestseller"
+	Reviews    PageType = "reviews"
+	Seller     PageType = "seller"
+	Captcha    PageType = "captcha"
+	NotFound   PageType = "not_found" // the "dog page" of deleted or never existing pages
+	SignIn     PageType = "sign_in"
+)
+
+// checked in order, the first matching path decides
+var urlRules = []struct {
+	pageType PageType
+	contains []string
+}{
+	{Captcha, []string{"/errors/validateCaptcha"}},
+	{SignIn, []string{"/ap/signin", "/ap/register"}},
+	{Reviews, []string{"/product-reviews/"}},
+	{Product, []string{"/dp/", "/gp/product/"}},
+	{Bestseller, []string{"/gp/bestsellers", "/zgbs/", "/Best-Sellers", "/gp/new-releases", "/gp/movers-and-shakers"}},
+	{Seller, []string{"/sp?", "/gp/aag/"}},
+	{Search, []string{"/s?", "/s/"}},
+	{Category, []string{"/b?", "/b/"}},
+}
+
+// Classifies the url before it's loaded.
+func FromURL(url string) PageType {
+	for _, rule := range urlRules {
+		for _, c := range rule.contains {
+			if strings.Contains(url, c) {
+				return rule.pageType
+			}
+		}
+	}
+	return Unknown
+}
+
+// Language specific urls like /-/es/ duplicate the content of the english pages.
+var languageRe = regexp.MustCompile(`/-/[a-z]{2}/`)
+
+func IsTranslated(url string) bool {
+	return languageRe.MatchString(url)
+}
+
+// checked in order, the first selector found on the page decides
+var domRules = []struct {
+	pageType PageType
+	selector string
+}{
+	{Captcha, `input#captchacharacters, div#challenge-container, form[action*="validateCaptcha"]`},
+	{SignIn, `form[name="signIn"], input#ap_email, input#ap_email_login`},
+	{NotFound, `img[alt*="Dogs of Amazon"], a[href*="ref=cs_404_logo"], a[href*="ref=cs_404_link"]`},
+	{Reviews, `#cm_cr-review_list`},
+	{Product, `#dp #productTitle, #dp-container #productTitle`},
+	{Bestseller, `#zg, #zg-right-col, .zg-grid-general-faceout`},
+	{Seller, `#seller-profile-container, #sellerName, #seller-name`},
+	{Search, `[data-component-type="s-search-result"]`},
+}
+
+// Classifies the loaded page. The DOM is checked first, because Amazon serves captchas,
+// sign-in walls and dead pages under every url. Falls back to the type of the url.
+func FromPage(url string, page playwright.Page) PageType {
+	for _, rule := range domRules {
+		count, err := page.Locator(rule.selector).Count()
+		if err == nil && count > 0 {
+			return rule.pageType
+		}
+	}
+	if notFoundTitle(page) {
+		return NotFound
+	}
+	return FromURL(url)
+}
+
+// Some dead pages lack the dog picture, but keep the title.
+func notFoundTitle(page playwright.Page) bool {
+	title, err := page.Title()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(title), "page not found")
+}
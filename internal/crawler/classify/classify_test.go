@@ -0,0 +1,39 @@
+package classify
+
+import "testing"
+
+func TestFromURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected PageType
+	}{
+		{"https://www.amazon.com/dp/B08N5WRWNW", Product},
+		{"https://www.amazon.com/LEGO-Classic/dp/B00NHQFA1I/ref=sr_1_1", Product},
+		{"https://www.amazon.com/gp/product/B00NHQFA1I", Product},
+		{"https://www.amazon.com/product-reviews/B00NHQFA1I", Reviews},
+		{"https://www.amazon.com/gp/bestsellers/toys-and-games", Bestseller},
+		{"https://www.amazon.com/Best-Sellers-Toys-Games/zgbs/toys-and-games", Bestseller},
+		{"https://www.amazon.com/sp?seller=A2L77EE7U53NWQ", Seller},
+		{"https://www.amazon.com/s?k=lego", Search},
+		{"https://www.amazon.com/s/toys", Search},
+		{"https://www.amazon.com/b?node=165793011", Category},
+		{"https://www.amazon.com/ap/signin?openid.return_to=x", SignIn},
+		{"https://www.amazon.com/errors/validateCaptcha?amzn=x", Captcha},
+		{"https://www.amazon.com/gp/help/customer/display.html", Unknown},
+	}
+
+	for _, test := range tests {
+		if got := FromURL(test.url); got != test.expected {
+			t.Errorf("FromURL(%q) = %s; want %s", test.url, got, test.expected)
+		}
+	}
+}
+
+func TestIsTranslated(t *testing.T) {
+	if !IsTranslated("https://www.amazon.com/-/es/s?k=lego") {
+		t.Error("expected /-/es/ url to be translated")
+	}
+	if IsTranslated("https://www.amazon.com/s?k=lego") {
+		t.Error("expected url without language to not be translated")
+	}
+}
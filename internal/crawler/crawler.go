@@ -2,53 +2,102 @@ package crawler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math/rand/v2"
-	"os"
-	"os/exec"
+	"net/http"
+	"net/url"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/breaker"
+	"github.com/jonashiltl/amazon-crawler/internal/budget"
+	"github.com/jonashiltl/amazon-crawler/internal/camoufox"
+	"github.com/jonashiltl/amazon-crawler/internal/canonical"
 	"github.com/jonashiltl/amazon-crawler/internal/consumer"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/facet"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/intercept"
 	"github.com/jonashiltl/amazon-crawler/internal/crawler/middleware"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/trap"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
 	"github.com/jonashiltl/amazon-crawler/internal/polite"
+	"github.com/jonashiltl/amazon-crawler/internal/proxy"
+	"github.com/jonashiltl/amazon-crawler/internal/recrawl"
+	"github.com/jonashiltl/amazon-crawler/internal/seed"
 	"github.com/jonashiltl/amazon-crawler/internal/storage"
 	"github.com/playwright-community/playwright-go"
-	"github.com/subsan/uafaker"
 )
 
 type crawler struct {
 	Options
-	browser             playwright.Browser
+	browser             *runningBrowser
+	browserMu           sync.RWMutex // held for writing while the browser is replaced
+	identity            *identity    // the identity of the running browser
+	restarting          atomic.Bool  // only one browser restart at a time
+	restarts            []time.Time  // recent restarts, guarded by restarting
+	coolDownUntil       atomic.Int64 // unix nanos until which no urls are leased after a block
+	budgetExhausted     atomic.Bool  // a global limit of the budget is reached
+	budgetStartedAt     time.Time    // when the budget run started, shared by all instances
 	pw                  *playwright.Playwright
-	ctx                 context.Context
+	ctx                 context.Context // cancelled to stop polling for new urls
+	workCtx             context.Context // outlives ctx so in-flight jobs can finish while draining
+	stopWork            context.CancelFunc
+	workers             sync.WaitGroup
+	newURLsDone         chan struct{} // closed once all found urls are queued in storage
 	log                 *slog.Logger
-	jobs                chan string // channel holding the urls to process
-	numWorkers          int         // number of workers to process the polled url
-	errorCount          int32
-	errorThreshold      int32                           // max number of errors before the crawler shuts down
-	newURLS             chan []string                   // extracted urls to queue in storage
+	jobs                chan storage.QueuedURL          // channel holding the urls to process
+	numWorkers          int                             // number of workers to process the polled url
+	breaker             *breaker.Breaker                // pauses fetching while too many requests fail
+	sessions            *sessionPool                    // browser contexts reused across urls
+	locationIdx         atomic.Uint64                   // location of the next session
+	newURLS             chan foundLinks                 // extracted links to queue in storage
 	requestMiddlewares  []middleware.RequestMiddleware  // exectued in order of their definition
 	responseMiddlewares []middleware.ResponseMiddleware // executed in order of their definition
 }
 
 type Options struct {
-	Consumer            consumer.Consumer
-	Storage             storage.Storage
-	SeedURLs            []string
-	PollInterval        time.Duration
-	Proxy               string
-	ProxyPW             string
-	ProxyUser           string
-	PlaywrightDriverDir string
-	Cancel              context.CancelFunc
+	Consumer             consumer.Consumer
+	Storage              storage.Storage
+	SeedURLs             []string
+	PollInterval         time.Duration
+	Proxies              proxy.Pool // browser contexts and robots.txt requests are spread over the pool
+	PlaywrightDriverDir  string
+	LeaseDuration        time.Duration // leases of running jobs are extended before they expire
+	RetryPolicies        crawlerr.Policies
+	Breaker              breaker.Options
+	BlockCoolDown        time.Duration   // no urls are leased for this long after a block
+	RestartOnBlock       bool            // restart the browser with a new identity when blocked
+	Launcher             BrowserLauncher // defaults to Camoufox
+	Sessions             SessionOptions
+	Locations            []string                       // delivery ZIP codes spread over the sessions, empty uses the location of the ip
+	RequestMiddlewares   []middleware.RequestMiddleware // executed after the robots check
+	Timezone             string                         // timezone of the browser contexts, empty keeps the browser's
+	Tombstones           bool                           // delist the products of removed pages from the consumer
+	MaxDepth             int                            // links further from their seed aren't queued, 0 disables
+	LinkPriority         func(storage.Link) int         // priority of found links for the best first frontier, defaults to DefaultLinkPriority
+	Recrawl              *recrawl.Scheduler             // schedules revisits of product pages, nil disables recrawling
+	RecrawlCheckInterval time.Duration                  // how often due revisits are queued
+	Budget               *budget.Tracker                // bounds the crawl, nil crawls until cancelled
+	Facets               *facet.Planner                 // splits searches with more results than Amazon shows, nil disables
+	Traps                *trap.Detector                 // stops following url patterns that lead nowhere, nil disables
+	Sitemaps             []string                       // sitemaps and sitemap indexes whose urls are queued
+	SitemapsFromRobots   bool                           // also queue the urls of the sitemaps listed in the robots.txt of every marketplace
+	Keywords             []seed.Keyword                 // searches queued as seeds, tagged with their keyword
+	Marketplaces         []string                       // hosts the keywords are searched on, defaults to Amazon's US marketplace
+	Interceptor          *intercept.Interceptor         // decides which requests of a page are sent, defaults to intercept.DefaultRules
+	MaxPagesPerBrowser   int                            // restart the browser after this many pages, 0 disables
+	MaxBrowserMemoryMB   int                            // restart the browser once it uses more memory, 0 disables
+	MaxRestarts          int                            // restarts allowed within the RestartWindow before the crawler stops
+	RestartWindow        time.Duration
+	HeartbeatInterval    time.Duration
+	Cancel               context.CancelFunc
 }
 
 func NewCrawler(ctx context.Context, opts Options) (*crawler, error) {
@@ -65,56 +114,141 @@ func NewCrawler(ctx context.Context, opts Options) (*crawler, error) {
 		return nil, fmt.Errorf("could not start playwright: %w", err)
 	}
 
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = 2 * time.Minute
+	}
+	if opts.RetryPolicies == nil {
+		opts.RetryPolicies = crawlerr.DefaultPolicies()
+	}
+	if opts.Launcher == nil {
+		opts.Launcher = NewCamoufoxLauncher(camoufox.DefaultLaunchOptions())
+	}
+	if len(opts.Marketplaces) == 0 {
+		opts.Marketplaces = []string{canonical.Host}
+	}
+	if opts.LinkPriority == nil {
+		opts.LinkPriority = DefaultLinkPriority
+	}
+	if opts.Interceptor == nil {
+		opts.Interceptor, _ = intercept.New(intercept.DefaultRules())
+	}
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = 5
+	}
+	if opts.RestartWindow <= 0 {
+		opts.RestartWindow = 10 * time.Minute
+	}
+	if opts.RecrawlCheckInterval <= 0 {
+		opts.RecrawlCheckInterval = 5 * time.Minute
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = 15 * time.Second
+	}
+
+	for i, seed := range opts.SeedURLs {
+		if u, err := canonical.URL(seed); err == nil {
+			opts.SeedURLs[i] = u
+		}
+	}
+
 	log.Info(fmt.Sprintf("polling every %s for queued urls", opts.PollInterval))
 	log.Info(fmt.Sprintf("using %d seed url", len(opts.SeedURLs)))
 
 	numWorkers := 10
+	if opts.Sessions.Size <= 0 {
+		opts.Sessions.Size = numWorkers
+	}
+	sessions, err := newSessionPool(opts.Sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	workCtx, stopWork := context.WithCancel(context.Background())
 	c := &crawler{
-		Options:        opts,
-		pw:             pw,
-		ctx:            ctx,
-		log:            log,
-		numWorkers:     numWorkers,
-		errorThreshold: 5,
-		jobs:           make(chan string, numWorkers*2),   // *2 gives buffer when workers can't keep up with poll volume
-		newURLS:        make(chan []string, numWorkers*2), // each worker produces one []string, of newly found, relevant urls
-		requestMiddlewares: []middleware.RequestMiddleware{
+		Options:     opts,
+		pw:          pw,
+		ctx:         ctx,
+		workCtx:     workCtx,
+		stopWork:    stopWork,
+		newURLsDone: make(chan struct{}),
+		log:         log,
+		numWorkers:  numWorkers,
+		breaker:     breaker.New(opts.Breaker),
+		sessions:    sessions,
+		jobs:        make(chan storage.QueuedURL, numWorkers*2), // *2 gives buffer when workers can't keep up with poll volume
+		newURLS:     make(chan foundLinks, numWorkers*2),        // each worker produces one foundLinks, of newly found, relevant urls
+		requestMiddlewares: append([]middleware.RequestMiddleware{
 			middleware.NewRobotsMiddleware(polite.Options{
-				Proxy:     opts.Proxy,
-				ProxyPW:   opts.ProxyPW,
-				ProxyUser: opts.ProxyUser,
+				Proxy: robotsProxy(opts.Proxies),
 			}),
-		},
+		}, opts.RequestMiddlewares...),
 		responseMiddlewares: []middleware.ResponseMiddleware{
 			middleware.NewLogMiddleware(),
 			middleware.NewCaptchaMiddleware(),
+			middleware.NewSignInMiddleware(),
+			middleware.NewNotFoundMiddleware(),
 			middleware.NewJSDisabledMiddleware(),
 		},
 	}
+	c.startNewURLConsumer()
 
 	return c, nil
 }
 
+// Requests robots.txt through the proxy pool, if there is one.
+func robotsProxy(pool proxy.Pool) func(*http.Request) (*url.URL, error) {
+	if pool == nil || pool.Len() == 0 {
+		return nil
+	}
+	return func(*http.Request) (*url.URL, error) {
+		p, err := pool.Next("robots")
+		if err != nil {
+			return nil, err
+		}
+		return p.URL()
+	}
+}
+
 func (c *crawler) Start() error {
-	wsURL, err := c.startCamoufox()
+	// a fixed INSTANCE_ID is reused after a restart, the leases of the previous process would look alive
+	released, err := c.Storage.ReleaseURLs(c.ctx)
 	if err != nil {
 		return err
 	}
+	if released > 0 {
+		c.log.Info(fmt.Sprintf("released %d urls leased by a previous run of this instance", released))
+	}
+
+	if err := c.restoreBudget(c.ctx); err != nil {
+		return err
+	}
 
-	err = c.connectBrowser(wsURL)
+	c.browserMu.Lock()
+	err = c.launchBrowser()
+	c.browserMu.Unlock()
 	if err != nil {
 		return err
 	}
+	c.startMemoryWatchdog()
 
 	// start the specififed number of workers
 	for i := range c.numWorkers {
+		c.workers.Add(1)
 		go c.worker(i)
 	}
-	c.startNewURLConsumer()
+	c.startHeartbeat()
+	c.startRecrawler()
+	c.startBudgetClock()
+
+	c.startSitemapDiscovery()
+	c.queueKeywords()
 
 	// process seed urls
 	for _, url := range c.SeedURLs {
-		c.get(url)
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		c.get(storage.QueuedURL{URL: url, Seed: url})
 		sleepWithJitter(c.PollInterval)
 	}
 
@@ -124,89 +258,60 @@ func (c *crawler) Start() error {
 	return nil
 }
 
-func (c *crawler) Close() {
-	c.browser.Close()
-}
+// Stops polling, waits for in-flight jobs to finish and releases the urls that are still leased
+// by this instance back to the queue. Jobs still running when ctx is done are aborted.
+// The consumer is flushed afterwards, so Close can safely be called once Drain returns.
+func (c *crawler) Drain(ctx context.Context) {
+	c.log.Info("draining crawler")
+	c.Cancel()
 
-func (c *crawler) startCamoufox() (string, error) {
-	slog.Info("starting Camoufox browser")
-	var proxyLine string
-	if c.Proxy != "" {
-		slog.Info(fmt.Sprintf("using proxy %s", c.Proxy))
-		proxyLine = `
-		geoip=True,
-		proxy={
-			'server':` + strconv.Quote(c.Proxy) + `,
-			'username':` + strconv.Quote(c.ProxyUser) + `,
-			'password':` + strconv.Quote(c.ProxyPW) + `
-		}
-		`
-	}
-
-	userAgent := uafaker.Windows().Firefox().Random()
-	slog.Info(fmt.Sprintf("using User-Agent %s", userAgent))
-
-	code := `
-from camoufox.server import launch_server
-from browserforge.fingerprints import Screen
-
-launch_server(
-    screen=Screen(max_width=1920, max_height=1080),
-    headless="virtual",
-	os="windows",
-	config={
-		"mediaDevices:enabled": True,
-		"navigator.userAgent": ` + strconv.Quote(userAgent) + `
-	},
-    block_images=True,
-	locale="en-US",
-    port=9222,
-    ws_path="play",
-	i_know_what_im_doing=True,
-	` + proxyLine + `
-)`
-
-	// use xvfb so that webgl is supported in docker container
-	cmd := exec.Command("xvfb-run", "-a", "-e", "/dev/stdout", "python3", "-c", code)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("failed to start Camoufox: %w", err)
-	}
-
-	// monitor camoufox health and stop crawler if camoufox exists
+	workersDone := make(chan struct{})
 	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			slog.Error("Camoufox exited with error", internal.ErrAttr(err))
-		} else {
-			slog.Warn("Camoufox exited cleanly")
-		}
-		c.Cancel()
+		c.workers.Wait()
+		close(workersDone)
 	}()
 
-	return "ws://localhost:9222/play", nil
-}
+	select {
+	case <-workersDone:
+		c.log.Info("all in-flight jobs finished")
+	case <-ctx.Done():
+		c.log.Warn("drain deadline exceeded, aborting in-flight jobs")
+		c.stopWork()
+		<-workersDone
+	}
 
-func (c *crawler) connectBrowser(wsURL string) error {
-	attempts := 5
-	sleep := time.Second * 2
-	for i := range attempts {
-		if i > 0 {
-			time.Sleep(sleep)
-			sleep *= 2
-		}
-		browser, err := c.pw.Firefox.Connect(wsURL)
-		if err != nil {
-			continue
-		}
-		c.browser = browser
-		c.log.Info(fmt.Sprintf("connected to browser at %s", wsURL))
-		return nil
+	// no worker sends anymore, so the url consumer can queue the remaining links and exit
+	close(c.newURLS)
+	<-c.newURLsDone
+
+	// the deadline only bounds the in-flight jobs, cleanup must still happen afterwards
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainCleanupTimeout)
+	defer cancel()
+
+	released, err := c.Storage.ReleaseURLs(cleanupCtx)
+	if err != nil {
+		c.log.Error("failed to release urls", internal.ErrAttr(err))
+	} else {
+		c.log.Info(fmt.Sprintf("released %d unfinished urls", released))
+	}
+
+	if err := c.Consumer.Flush(cleanupCtx); err != nil {
+		c.log.Error("failed to flush consumer", internal.ErrAttr(err))
 	}
 
-	return fmt.Errorf("failed to connect to %s", wsURL)
+	c.stopWork()
+}
+
+const drainCleanupTimeout = 10 * time.Second
+
+func (c *crawler) Close() {
+	if c.browser != nil {
+		c.closeSessions()
+		c.browser.close()
+	}
+	if c.identity != nil {
+		c.saveIdentity(context.Background(), c.identity, true)
+	}
 }
 
 func (c *crawler) poll() {
@@ -218,48 +323,121 @@ func (c *crawler) poll() {
 		default:
 		}
 
-		queuedURL, err := c.Storage.GetNextURL(c.ctx)
+		// a crashed browser can't serve jobs until its replacement is launched
+		c.browserMu.RLock()
+		crashed := c.browser.crashed.Load()
+		c.browserMu.RUnlock()
+		if crashed {
+			sleepWithJitter(c.PollInterval)
+			continue
+		}
+
+		// don't lease urls while the circuit breaker is open or after being blocked
+		if err := c.breaker.Wait(c.ctx, time.Second); err != nil {
+			continue
+		}
+		if err := c.waitCoolDown(c.ctx); err != nil {
+			continue
+		}
+
+		c.browserMu.RLock()
+		identityID := c.identity.id
+		c.browserMu.RUnlock()
+
+		queuedURL, err := c.Storage.GetNextURL(c.ctx, identityID)
 		if err != nil {
 			c.log.Error(err.Error())
+			c.breaker.Release()
 			sleepWithJitter(c.PollInterval)
 			continue
 		}
 
 		if queuedURL.URL == "" {
+			// no job records an outcome for the probe
+			c.breaker.Release()
 			sleepWithJitter(c.PollInterval)
 			continue
 		}
 
-		c.get(queuedURL.URL)
+		c.get(queuedURL)
 		sleepWithJitter(c.PollInterval)
 	}
 }
 
-// Consumes the newly found urls and queues them in storage.
-func (c *crawler) startNewURLConsumer() {
+// Periodically signals other instances that this crawler is alive,
+// so they don't reclaim its leased urls.
+func (c *crawler) startHeartbeat() {
+	ticker := time.NewTicker(c.HeartbeatInterval)
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
-			case <-c.ctx.Done():
+			case <-c.workCtx.Done():
 				return
-			case links, ok := <-c.newURLS:
-				if !ok {
-					return
+			case <-ticker.C:
+				if err := c.Storage.Heartbeat(c.workCtx); err != nil {
+					c.log.Error(err.Error())
 				}
-				err := c.Storage.AddURLs(c.ctx, links)
-				if err != nil {
+				c.browserMu.RLock()
+				id := c.identity
+				c.browserMu.RUnlock()
+				c.saveIdentity(c.workCtx, id, false)
+			}
+		}
+	}()
+}
+
+// Extends the lease of the url until the returned stop function is called.
+func (c *crawler) keepLease(url string) (stop func()) {
+	done := make(chan struct{})
+	// extend well before the lease expires to tolerate slow storage
+	ticker := time.NewTicker(c.LeaseDuration / 3)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.workCtx.Done():
+				return
+			case <-ticker.C:
+				if err := c.Storage.ExtendLease(c.workCtx, url); err != nil {
 					c.log.Error(err.Error())
 				}
 			}
 		}
 	}()
+	return func() { close(done) }
+}
+
+// The relevant links found on a crawled page.
+type foundLinks struct {
+	page  string
+	links []storage.Link
+}
+
+// Consumes the newly found urls and queues them in storage.
+// Runs until newURLS is closed, so links of jobs finishing during a drain aren't lost.
+func (c *crawler) startNewURLConsumer() {
+	go func() {
+		defer close(c.newURLsDone)
+		for found := range c.newURLS {
+			added, err := c.Storage.AddURLs(context.WithoutCancel(c.workCtx), found.links)
+			if err != nil {
+				// unknown whether the page led to new urls, it mustn't count as a dead end
+				c.log.Error(err.Error())
+				continue
+			}
+			c.observeTraps(found.page, added)
+		}
+	}()
 }
 
 // Adds the url to the internal job queue.
 // Blocks if the channel (buffered) is full.
-func (c *crawler) get(url string) {
+func (c *crawler) get(job storage.QueuedURL) {
 	select {
-	case c.jobs <- url:
+	case c.jobs <- job:
 	case <-c.ctx.Done():
 		return
 	}
@@ -267,134 +445,269 @@ func (c *crawler) get(url string) {
 }
 
 func (c *crawler) worker(id int) {
+	defer c.workers.Done()
 	c.log.Info(fmt.Sprintf("created worker %d, waiting on urls...", id))
 	for {
 		select {
 		case <-c.ctx.Done():
 			c.log.Info(fmt.Sprintf("worker %d shutting down", id))
 			return
-		case url, ok := <-c.jobs:
+		case job, ok := <-c.jobs:
 			if !ok {
 				return
 			}
-			c.processJob(url)
+			// both cases might be ready, don't start new jobs while draining.
+			// The url stays leased and is released by Drain.
+			if c.ctx.Err() != nil {
+				c.log.Info(fmt.Sprintf("worker %d shutting down", id))
+				return
+			}
+			c.processJob(job)
 		}
 	}
 }
 
-func (c *crawler) processJob(url string) {
-	jobCtx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+func (c *crawler) processJob(job storage.QueuedURL) {
+	url := job.URL
+	jobCtx, cancel := context.WithTimeout(c.workCtx, 30*time.Second)
 	defer cancel()
 
-	links, err := c.processURL(jobCtx, url)
+	stopLease := c.keepLease(url)
+	defer stopLease()
+
+	// the browser mustn't be replaced while it's used
+	c.browserMu.RLock()
+	id := c.identity
+	browser := c.browser
+	links, err := c.processURL(jobCtx, job)
+	c.browserMu.RUnlock()
+	id.requests.Add(1)
+	c.checkPageLimit(id)
+
 	if err != nil {
-		c.onError(c.ctx, url, err)
+		if c.workCtx.Err() != nil {
+			// aborted while draining, the url is released instead of marked failed
+			return
+		}
+		if browser.crashed.Load() {
+			// not the url's fault, retry it once the browser is restarted
+			c.requeue(job)
+			c.breaker.Release()
+			return
+		}
+		if isBlock(crawlerr.ClassOf(err)) {
+			c.onBlocked(c.workCtx, job, id, err)
+			return
+		}
+		if isGone(crawlerr.ClassOf(err)) {
+			c.onGone(c.workCtx, job, err)
+			return
+		}
+		c.onError(c.workCtx, job, id, err)
 		return
 	}
 
 	select {
-	case c.newURLS <- links:
-	case <-c.ctx.Done():
+	case c.newURLS <- foundLinks{page: url, links: links}:
+	case <-c.workCtx.Done():
 		return
 	}
 
-	if err := c.Storage.MarkDone(c.ctx, url); err != nil {
+	c.breaker.Success()
+
+	if err := c.Storage.MarkDone(c.workCtx, url); err != nil {
 		c.log.Error("mark done error: " + err.Error())
 	}
 }
 
-var blocked_resources = []string{"stylesheet", "font", "media", "image", "other", "xhr"}
+// Fetches the url in a pooled session, parses the page and returns new relevant links.
+func (c *crawler) processURL(ctx context.Context, job storage.QueuedURL) ([]storage.Link, error) {
+	url := job.URL
+	s, err := c.acquireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// set on the first page of a marketplace, the popover of another marketplace doesn't apply to it
+	if err := c.ensureLocation(ctx, s, url); err != nil {
+		c.releaseSession(s, "")
+		return nil, fmt.Errorf("failed to set delivery location: %w", err)
+	}
 
-// Fetches the url, parses the page and returns new relevant links.
-func (c *crawler) processURL(ctx context.Context, url string) ([]string, error) {
-	context, err := c.browser.NewContext()
+	page, err := s.context.NewPage()
 	if err != nil {
+		c.releaseSession(s, "failed to open page")
 		return nil, err
 	}
+	urlType := classify.FromURL(url)
+	page.Route("**/*", func(r playwright.Route) {
+		req := r.Request()
+		if c.Interceptor.Block(string(urlType), url, req.URL(), req.ResourceType()) {
+			r.Abort()
+		} else {
+			r.Continue()
+		}
+	})
 
 	// use sync.Once to make sure Close is only called once
 	var once sync.Once
-	closeCtx := func() {
+	closePage := func() {
 		once.Do(func() {
-			context.Close()
+			page.Close()
 		})
 	}
 	// Ensure it's closed at the end
-	defer closeCtx()
+	defer closePage()
 
 	// Also close if context is cancelled
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		<-ctx.Done()
-		closeCtx()
+		select {
+		case <-ctx.Done():
+			closePage()
+		case <-done:
+		}
 	}()
 
-	page, err := context.NewPage()
-	if err != nil {
-		return nil, err
+	meter := meterBytes(page)
+	links, err := c.fetch(ctx, page, job, s)
+	if c.Budget != nil {
+		c.recordUsage(context.WithoutCancel(ctx), budget.Usage{budget.Pages: 1, budget.Bytes: meter.total()})
 	}
-	page.Route("**/*", func(r playwright.Route) {
-		if slices.Contains(blocked_resources, r.Request().ResourceType()) {
-			r.Abort()
-		} else {
-			r.Continue()
+	closePage()
+	// a blocked session's cookies are flagged, continuing it only leads to more captchas.
+	// A session that can't be restored would carry the middlewares' changes to other urls.
+	var retireReason string
+	if err != nil && isBlock(crawlerr.ClassOf(err)) {
+		retireReason = "blocked"
+	}
+	if restored := c.restoreMiddlewares(url, page); !restored && retireReason == "" {
+		retireReason = "failed to restore middlewares"
+	}
+	c.releaseSession(s, retireReason)
+	return links, err
+}
+
+// Undoes the changes of the request middlewares to the session and reports whether all succeeded.
+func (c *crawler) restoreMiddlewares(url string, page playwright.Page) bool {
+	ok := true
+	for _, mw := range c.requestMiddlewares {
+		r, isRestorer := mw.(middleware.Restorer)
+		if !isRestorer {
+			continue
 		}
-	})
+		if err := r.Restore(url, page); err != nil {
+			c.log.Warn("failed to restore session after middleware", internal.ErrAttr(err), slog.String("url", url))
+			ok = false
+		}
+	}
+	return ok
+}
 
+// Runs the middlewares around loading the url and reports the outcome to the proxy pool.
+func (c *crawler) fetch(ctx context.Context, page playwright.Page, job storage.QueuedURL, s *session) ([]storage.Link, error) {
+	url := job.URL
+	usedProxy := s.proxy
 	for _, mw := range c.requestMiddlewares {
 		if err := mw.Process(ctx, url, page); err != nil {
 			return nil, err
 		}
 	}
 
+	start := time.Now()
 	res, err := page.Goto(url, playwright.PageGotoOptions{
 		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
 	})
+	latency := time.Since(start)
 	if err != nil {
+		c.recordProxy(usedProxy, err, latency)
 		return nil, err
 	}
-	if !res.Ok() {
-		return nil, fmt.Errorf("response status %d", res.Status())
-	}
 
+	// middlewares also run for error responses, dead pages and captchas come with any status
+	pageType := classify.FromPage(url, page)
 	for _, mw := range c.responseMiddlewares {
-		if err := mw.Process(ctx, url, page, res); err != nil {
+		if err := mw.Process(ctx, url, pageType, page, res); err != nil {
+			c.recordProxy(usedProxy, err, latency)
 			return nil, err
 		}
 	}
-
-	c.resetErrorCount()
-
-	if strings.Contains(url, "/dp/") {
-		err := c.parseProductDetails(ctx, page)
+	if !res.Ok() {
+		err := crawlerr.HTTPStatus(res.Status())
+		c.recordProxy(usedProxy, err, latency)
+		return nil, err
+	}
+	c.recordProxy(usedProxy, nil, latency)
+
+	switch pageType {
+	case classify.Product:
+		product, err := c.parseProductDetails(ctx, page, job, s.locations[hostOf(url)])
+		if errors.Is(err, errOverQuota) {
+			c.log.Debug("quota reached, skipping product", slog.String("url", url))
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
+		c.scheduleRevisit(ctx, url, product)
 	}
 
-	return c.getRelevantLinks(page)
+	links := c.getRelevantLinks(page, pageType, job)
+	if pageType == classify.Search {
+		links = append(links, c.expandSearch(ctx, page, job)...)
+	}
+	return c.withoutTraps(c.withinBudget(c.withinDepth(links))), nil
 }
 
-func (c *crawler) onError(ctx context.Context, url string, err error) {
-	msg := err.Error()
-	c.log.Error(msg, slog.String("url", url))
-	err = c.Storage.MarkFailed(ctx, url, msg)
-	if err != nil {
-		c.log.Error(err.Error())
+// Reports the outcome of fetching a page to the proxy pool.
+func (c *crawler) recordProxy(p *proxy.Proxy, err error, latency time.Duration) {
+	if p == nil {
+		return
+	}
+	c.Proxies.Record(*p, proxyOutcome(err), latency)
+}
+
+// Decides whether the proxy is to blame for the error.
+func proxyOutcome(err error) proxy.Outcome {
+	if err == nil {
+		return proxy.Success
 	}
 
-	newCount := c.incrementErrorCount()
-	if newCount > c.errorThreshold {
-		c.log.Error("too many errors, shutting down", slog.Int("count", int(newCount)))
-		c.Cancel()
+	var crawlErr *crawlerr.Error
+	errors.As(err, &crawlErr)
+
+	switch crawlerr.ClassOf(err) {
+	case crawlerr.Captcha:
+		return proxy.Captcha
+	case crawlerr.Timeout:
+		return proxy.Timeout
+	case crawlerr.HTTPServer, crawlerr.Throttled:
+		return proxy.HTTPError
+	case crawlerr.HTTPClient:
+		if crawlErr.Status == http.StatusProxyAuthRequired {
+			return proxy.HTTPError
+		}
+		return proxy.Success // the page doesn't exist, but the proxy delivered the response
+	case crawlerr.Unknown:
+		return proxy.HTTPError // e.g. the connection to the proxy failed
+	default:
+		return proxy.Success
 	}
 }
 
-func (c *crawler) incrementErrorCount() int32 {
-	return atomic.AddInt32(&c.errorCount, 1)
-}
+// Marks the url as failed and schedules its retry according to the policy of the error class.
+func (c *crawler) onError(ctx context.Context, job storage.QueuedURL, id *identity, err error) {
+	class := crawlerr.ClassOf(err)
+	c.log.Error(err.Error(), slog.String("url", job.URL), slog.String("class", string(class)))
+
+	failure := c.failure(job, class, err, id)
+
+	err = c.Storage.MarkFailed(ctx, job.URL, failure)
+	if err != nil {
+		c.log.Error(err.Error())
+	}
 
-func (c *crawler) resetErrorCount() {
-	atomic.StoreInt32(&c.errorCount, 0)
+	c.breaker.Failure(class)
 }
 
 func (c *crawler) takeScreenshot(p playwright.Page) {
@@ -409,56 +722,110 @@ func (c *crawler) takeScreenshot(p playwright.Page) {
 	}
 }
 
-func (c *crawler) parseProductDetails(ctx context.Context, page playwright.Page) error {
+func (c *crawler) parseProductDetails(ctx context.Context, page playwright.Page, job storage.QueuedURL, location string) (internal.Product, error) {
 	product, err := internal.ProductFromPage(page)
 	if err != nil {
-		return fmt.Errorf("failed to parse product: %w", err)
+		return internal.Product{}, crawlerr.Newf(crawlerr.Parse, "failed to parse product: %w", err)
 	}
+	if c.Budget != nil && c.Budget.QuotaReached(product.Categories, job.Seed) {
+		return product, errOverQuota
+	}
+	product.Location = location
+	product.Keyword = job.Tag
+	product.Marketplace = hostOf(job.URL)
 	c.log.Debug("product parsed", slog.String("url", page.URL()))
 
 	err = c.Consumer.Consume(ctx, product)
 	if err != nil {
-		return fmt.Errorf("failed to consume product: %w", err)
+		return internal.Product{}, crawlerr.Newf(crawlerr.Consumer, "failed to consume product: %w", err)
 	}
-	return nil
+	if c.Budget != nil {
+		c.recordProduct(ctx, product, job.Seed)
+	}
+	return product, nil
 }
 
-// Finds all relevant links, e.g. product details or search pages and adds them to the queue
-func (c *crawler) getRelevantLinks(page playwright.Page) ([]string, error) {
-	links := mapset.NewThreadUnsafeSet[string]()
+// Links to the next page of listings, by the type of the listing.
+var paginationSelectors = map[classify.PageType]string{
+	classify.Search:     "a.s-pagination-next",
+	classify.Category:   "a.s-pagination-next, a#apb-desktop-browse-search-see-all",
+	classify.Bestseller: ".a-pagination li.a-last a",
+}
 
-	a, err := page.Locator("a[href]").All()
-	if err == nil {
-		for _, link := range a {
-			href, err := link.GetAttribute("href")
-			if err != nil {
-				continue
-			}
+// Links to products frequently bought together with the product of the page.
+const boughtTogetherSelector = "#sims-fbt a[href], #similarities_feature_div a[href], [data-csa-c-slot-id*=\"fbt\"] a[href]"
+
+// Finds all relevant links, e.g. product details or search pages, and records how they were found.
+// Links found in a specific section are collected first, so a link keeps the most telling context.
+func (c *crawler) getRelevantLinks(page playwright.Page, pageType classify.PageType, job storage.QueuedURL) []storage.Link {
+	links := make(map[string]storage.Link)
+	baseURL := baseURLOf(page.URL())
+	add := func(href string, context storage.LinkContext) {
+		url := withBaseURL(baseURL, href)
+		if url == "" {
+			return
+		}
+		if _, ok := links[url]; !ok {
+			link := childLink(job, url, context)
+			link.Priority = c.LinkPriority(link)
+			links[url] = link
+		}
+	}
+
+	if pagination, ok := paginationSelectors[pageType]; ok {
+		for _, href := range hrefs(page, pagination) {
+			add(href, storage.LinkPagination)
+		}
+	}
 
+	if pageType == classify.Product {
+		for _, href := range hrefs(page, boughtTogetherSelector) {
 			if asin, err := internal.AsinFromURL(href); err == nil {
-				links.Add(createProductURL(asin))
+				add("/dp/"+asin, storage.LinkBoughtTogether)
 			}
+		}
+	}
 
-			if isRelevantURL(href) {
-				links.Add(withBaseURL(href))
-			}
+	for _, href := range hrefs(page, "a[href]") {
+		if asin, err := internal.AsinFromURL(href); err == nil {
+			add("/dp/"+asin, storage.LinkProduct)
+		}
+
+		// refined searches are only queued as planned partitions, following every filter explodes the queue
+		if isRelevantURL(href) {
+			add(canonical.WithoutRefinements(href), linkContextOf(href))
 		}
 	}
 
-	a, err = page.Locator("a.s-pagination-next, a#apb-desktop-browse-search-see-all").All()
-	if err == nil {
-		for _, link := range a {
-			href, err := link.GetAttribute("href")
-			if err != nil {
-				continue
-			}
-			links.Add(withBaseURL(href))
+	c.log.Debug(fmt.Sprintf("found %d relevant links", len(links)))
+	return slices.Collect(maps.Values(links))
+}
+
+// Returns the href attributes of the elements matching the selector.
+func hrefs(page playwright.Page, selector string) []string {
+	elements, err := page.Locator(selector).All()
+	if err != nil {
+		return nil
+	}
+	var hrefs []string
+	for _, el := range elements {
+		href, err := el.GetAttribute("href")
+		if err != nil || href == "" {
+			continue
 		}
+		hrefs = append(hrefs, href)
 	}
+	return hrefs
+}
 
-	slice := links.ToSlice()
-	c.log.Debug(fmt.Sprintf("found %d relevant links", len(slice)))
-	return slice, nil
+// Drops the links deeper than MaxDepth.
+func (c *crawler) withinDepth(links []storage.Link) []storage.Link {
+	if c.MaxDepth <= 0 {
+		return links
+	}
+	return slices.DeleteFunc(links, func(l storage.Link) bool {
+		return l.Depth > c.MaxDepth
+	})
 }
 
 func sleepWithJitter(base time.Duration) {
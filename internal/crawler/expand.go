@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/facet"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	resultCountSelector = `[data-component-type="s-result-info-bar"] h1 span, [data-component-type="s-result-info-bar"] h2 span`
+	refinementsSelector = `#s-refinements a[href*="rh="]`
+)
+
+// Splits a search whose results Amazon doesn't show completely into sub-searches,
+// so the long tail of big categories is reached. Products found by several partitions
+// are queued once, as they share the canonical key of the product.
+func (c *crawler) expandSearch(ctx context.Context, page playwright.Page, job storage.QueuedURL) []storage.Link {
+	if c.Facets == nil {
+		return nil
+	}
+
+	texts, err := page.Locator(resultCountSelector).AllTextContents()
+	if err != nil {
+		return nil
+	}
+	var results facet.Results
+	var ok bool
+	for _, text := range texts {
+		if results, ok = facet.ParseResults(text); ok {
+			break
+		}
+	}
+	if !ok {
+		c.log.Debug("no result count on search page", slog.String("url", job.URL))
+		return nil
+	}
+
+	// a search is planned once, not once per result page
+	search := facet.SearchKey(job.URL)
+	partition := storage.Partition{
+		URL:     search,
+		Results: results.Total,
+		Capped:  c.Facets.Capped(results),
+	}
+	var subs []string
+	if partition.Capped {
+		var dim facet.Dimension
+		dim, subs = c.Facets.Split(search, hrefs(page, refinementsSelector))
+		partition.SplitBy = string(dim)
+		partition.Children = subs
+	}
+
+	first, err := c.Storage.SavePartition(ctx, partition)
+	if err != nil {
+		c.log.Error(err.Error())
+	}
+	if !first || len(subs) == 0 {
+		return nil
+	}
+
+	c.log.Debug(fmt.Sprintf("split search into %d sub-searches", len(subs)), slog.String("url", search), slog.String("by", partition.SplitBy), slog.Int("results", results.Total))
+	links := make([]storage.Link, 0, len(subs))
+	for _, sub := range subs {
+		link := childLink(job, sub, storage.LinkFacet)
+		link.Priority = c.LinkPriority(link)
+		links = append(links, link)
+	}
+	return links
+}
@@ -0,0 +1,255 @@
+package facet
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jonashiltl/amazon-crawler/internal/canonical"
+)
+
+// An attribute searches are split by.
+type Dimension string
+
+const (
+	Node   Dimension = "node"   // sub-categories of the searched category
+	Brand  Dimension = "brand"  // brands listed in the refinements of the search
+	Price  Dimension = "price"  // price ranges, split in halves until they fit
+	Rating Dimension = "rating" // minimum customer ratings, the ranges overlap
+)
+
+// the refinement keys of the dimensions in the "rh" parameter
+var refinementKeys = map[Dimension]string{
+	Node:   "n",
+	Brand:  "p_89",
+	Price:  "p_36",
+	Rating: "p_72",
+}
+
+func ParseDimensions(names []string) ([]Dimension, error) {
+	dims := make([]Dimension, 0, len(names))
+	for _, name := range names {
+		d := Dimension(strings.TrimSpace(name))
+		if _, ok := refinementKeys[d]; !ok {
+			return nil, fmt.Errorf("unknown facet dimension %q, expected node, brand, price or rating", name)
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+type Options struct {
+	Dimensions   []Dimension // tried in order, the first that splits a search is used
+	MaxPages     int         // Amazon doesn't show more result pages of a search
+	PriceBounds  []int       // bounds of the initial price ranges in cents, the last range is open
+	MinPriceSpan int         // price ranges aren't split below this span in cents
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Dimensions:   []Dimension{Node, Brand, Price, Rating},
+		MaxPages:     7,
+		PriceBounds:  []int{0, 1000, 2500, 5000, 10000, 20000, 50000},
+		MinPriceSpan: 100,
+	}
+}
+
+// The result count shown above the results of a search.
+type Results struct {
+	PageSize int
+	Total    int
+	Over     bool // the total is a lower bound, e.g. "over 50,000 results"
+}
+
+var (
+	rangeResultsRe = regexp.MustCompile(`(\d[\d,]*)\s*-\s*(\d[\d,]*)\s+of\s+(over\s+)?(\d[\d,]*)\s+results`)
+	allResultsRe   = regexp.MustCompile(`^\s*(over\s+)?(\d[\d,]*)\s+results`)
+)
+
+// Parses texts like "1-48 of over 50,000 results for" or "12 results for".
+func ParseResults(text string) (Results, bool) {
+	if m := rangeResultsRe.FindStringSubmatch(text); m != nil {
+		first, last, total := number(m[1]), number(m[2]), number(m[4])
+		return Results{PageSize: last - first + 1, Total: total, Over: m[3] != ""}, total > 0 && last >= first
+	}
+	if m := allResultsRe.FindStringSubmatch(text); m != nil {
+		total := number(m[2])
+		return Results{PageSize: total, Total: total, Over: m[1] != ""}, total > 0
+	}
+	return Results{}, false
+}
+
+func number(s string) int {
+	n, _ := strconv.Atoi(strings.ReplaceAll(s, ",", ""))
+	return n
+}
+
+// Splits searches whose results don't fit on the pages Amazon shows into sub-searches.
+// Sub-searches are refined versions of the search, so splitting them again narrows them down further.
+type Planner struct {
+	opts Options
+}
+
+func NewPlanner(opts Options) (*Planner, error) {
+	if opts.MaxPages <= 0 {
+		return nil, fmt.Errorf("max pages of a search must be positive, got %d", opts.MaxPages)
+	}
+	if len(opts.Dimensions) == 0 {
+		return nil, fmt.Errorf("no facet dimensions")
+	}
+	if !slices.IsSorted(opts.PriceBounds) || len(opts.PriceBounds) < 2 {
+		return nil, fmt.Errorf("price bounds must be at least two ascending prices, got %v", opts.PriceBounds)
+	}
+	return &Planner{opts: opts}, nil
+}
+
+// Reports whether Amazon hides some results of the search.
+func (p *Planner) Capped(r Results) bool {
+	if r.Over {
+		return r.Total >= r.PageSize*p.opts.MaxPages
+	}
+	return r.Total > r.PageSize*p.opts.MaxPages
+}
+
+// Returns the sub-searches of the search and the dimension they were split by.
+// Values of the node, brand and rating dimensions are taken from the refinement links of the search page.
+// Returns no sub-searches if no dimension splits the search into at least two.
+func (p *Planner) Split(searchURL string, refinementLinks []string) (Dimension, []string) {
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return "", nil
+	}
+	current := canonical.ParseRefinements(u.Query().Get("rh"))
+	offered := offeredValues(refinementLinks)
+
+	for _, dim := range p.opts.Dimensions {
+		key := refinementKeys[dim]
+		var values []string
+		switch dim {
+		case Price:
+			values = p.priceRanges(current[key])
+		case Node:
+			// a sub-category replaces the category. Links back to parent categories are
+			// offered as well, but their searches are already queued.
+			values = without(offered[key], current[key])
+		default:
+			// brands and ratings are only refined once, the values of a refined search are alternatives
+			if len(current[key]) == 0 {
+				values = offered[key]
+			}
+		}
+		if len(values) < 2 {
+			continue
+		}
+
+		subs := make([]string, 0, len(values))
+		for _, v := range values {
+			refinements := clone(current)
+			refinements[key] = []string{v}
+			if sub, err := withRefinements(u, refinements); err == nil {
+				subs = append(subs, sub)
+			}
+		}
+		return dim, subs
+	}
+	return "", nil
+}
+
+// Returns the ranges splitting the refined price range, or the initial ranges if the price isn't refined.
+func (p *Planner) priceRanges(current []string) []string {
+	bounds := p.opts.PriceBounds
+	if len(current) == 0 {
+		ranges := make([]string, 0, len(bounds))
+		for i := 0; i < len(bounds)-1; i++ {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", bounds[i], bounds[i+1]))
+		}
+		return append(ranges, fmt.Sprintf("%d-", bounds[len(bounds)-1]))
+	}
+	if len(current) != 1 {
+		return nil
+	}
+
+	loStr, hiStr, ok := strings.Cut(current[0], "-")
+	if !ok {
+		return nil
+	}
+	lo, err := strconv.Atoi(loStr)
+	if err != nil {
+		return nil
+	}
+	if hiStr == "" {
+		// the open range above all bounds is split at twice its lower bound
+		if lo <= 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("%d-%d", lo, 2*lo), fmt.Sprintf("%d-", 2*lo)}
+	}
+	hi, err := strconv.Atoi(hiStr)
+	if err != nil || hi-lo < 2*p.opts.MinPriceSpan {
+		return nil
+	}
+	mid := lo + (hi-lo)/2
+	return []string{fmt.Sprintf("%d-%d", lo, mid), fmt.Sprintf("%d-%d", mid, hi)}
+}
+
+// Collects the values of the refinement links, by refinement key.
+func offeredValues(links []string) canonical.Refinements {
+	offered := make(canonical.Refinements)
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		for key, values := range canonical.ParseRefinements(u.Query().Get("rh")) {
+			for _, v := range values {
+				if !slices.Contains(offered[key], v) {
+					offered[key] = append(offered[key], v)
+				}
+			}
+		}
+	}
+	return offered
+}
+
+func without(values []string, remove []string) []string {
+	return slices.DeleteFunc(slices.Clone(values), func(v string) bool {
+		return slices.Contains(remove, v)
+	})
+}
+
+func clone(r canonical.Refinements) canonical.Refinements {
+	clone := make(canonical.Refinements, len(r)+1)
+	for key, values := range r {
+		clone[key] = slices.Clone(values)
+	}
+	return clone
+}
+
+// Returns the url identifying the search, which is its first page.
+// All result pages of a search show the same result count, so they share one partition.
+func SearchKey(searchURL string) string {
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return searchURL
+	}
+	q := u.Query()
+	if !q.Has("page") {
+		return searchURL
+	}
+	q.Del("page")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Returns the first page of the search with the refinements.
+func withRefinements(search *url.URL, refinements canonical.Refinements) (string, error) {
+	u := *search
+	q := u.Query()
+	q.Set("rh", refinements.String())
+	q.Del("page")
+	u.RawQuery = q.Encode()
+	return canonical.URL(u.String())
+}
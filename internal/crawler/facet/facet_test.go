@@ -0,0 +1,188 @@
+package facet
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseResults(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected Results
+		ok       bool
+	}{
+		{"1-48 of over 50,000 results for \"lego\"", Results{PageSize: 48, Total: 50000, Over: true}, true},
+		{"1-16 of 312 results for", Results{PageSize: 16, Total: 312}, true},
+		{"49-96 of 1,204 results for", Results{PageSize: 48, Total: 1204}, true},
+		{"12 results for \"lego technic 42083\"", Results{PageSize: 12, Total: 12}, true},
+		{"Results", Results{}, false},
+		{"", Results{}, false},
+	}
+
+	for _, test := range tests {
+		result, ok := ParseResults(test.text)
+		if ok != test.ok || result != test.expected {
+			t.Errorf("ParseResults(%q) = %+v, %v; want %+v, %v", test.text, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestCapped(t *testing.T) {
+	p, err := NewPlanner(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		results  Results
+		expected bool
+	}{
+		{Results{PageSize: 48, Total: 50000, Over: true}, true},
+		{Results{PageSize: 48, Total: 336}, false},
+		{Results{PageSize: 48, Total: 337}, true},
+		{Results{PageSize: 16, Total: 12}, false},
+	}
+
+	for _, test := range tests {
+		if result := p.Capped(test.results); result != test.expected {
+			t.Errorf("Capped(%+v) = %v; want %v", test.results, result, test.expected)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	refinementLinks := []string{
+		"/s?k=lego&rh=n%3A165793011%2Cn%3A166092011&dc",
+		"/s?k=lego&rh=n%3A165793011%2Cn%3A196601011&dc",
+		"/s?k=lego&rh=n%3A165793011%2Cp_89%3ALEGO",
+		"/s?k=lego&rh=n%3A165793011%2Cp_89%3AMega+Bloks",
+		"/s?k=lego&rh=n%3A165793011%2Cp_72%3A1248864011",
+		"/s?k=lego&rh=n%3A165793011%2Cp_72%3A1248865011",
+	}
+
+	tests := []struct {
+		name      string
+		dims      []Dimension
+		search    string
+		links     []string
+		expectDim Dimension
+		expected  []string
+	}{
+		{
+			name:      "sub-categories",
+			dims:      []Dimension{Node, Brand},
+			search:    "https://www.amazon.com/s?k=lego&rh=n%3A165793011",
+			links:     refinementLinks,
+			expectDim: Node,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=n%3A166092011",
+				"https://www.amazon.com/s?k=lego&rh=n%3A196601011",
+			},
+		},
+		{
+			name:      "brands",
+			dims:      []Dimension{Brand, Node},
+			search:    "https://www.amazon.com/s?k=lego&rh=n%3A165793011&page=3",
+			links:     refinementLinks,
+			expectDim: Brand,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=n%3A165793011%2Cp_89%3ALEGO",
+				"https://www.amazon.com/s?k=lego&rh=n%3A165793011%2Cp_89%3AMega+Bloks",
+			},
+		},
+		{
+			name:      "refined brand falls through to rating",
+			dims:      []Dimension{Brand, Rating},
+			search:    "https://www.amazon.com/s?k=lego&rh=n%3A165793011%2Cp_89%3ALEGO",
+			links:     refinementLinks,
+			expectDim: Rating,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=n%3A165793011%2Cp_72%3A1248864011%2Cp_89%3ALEGO",
+				"https://www.amazon.com/s?k=lego&rh=n%3A165793011%2Cp_72%3A1248865011%2Cp_89%3ALEGO",
+			},
+		},
+		{
+			name:      "initial price ranges",
+			dims:      []Dimension{Price},
+			search:    "https://www.amazon.com/s?k=lego",
+			expectDim: Price,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A0-1000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A1000-2500",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A2500-5000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A5000-10000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A10000-20000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A20000-50000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A50000-",
+			},
+		},
+		{
+			name:      "price range in halves",
+			dims:      []Dimension{Price},
+			search:    "https://www.amazon.com/s?k=lego&rh=p_36%3A1000-2500",
+			expectDim: Price,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A1000-1750",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A1750-2500",
+			},
+		},
+		{
+			name:      "open price range",
+			dims:      []Dimension{Price},
+			search:    "https://www.amazon.com/s?k=lego&rh=p_36%3A50000-",
+			expectDim: Price,
+			expected: []string{
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A50000-100000",
+				"https://www.amazon.com/s?k=lego&rh=p_36%3A100000-",
+			},
+		},
+		{
+			name:   "price range too narrow",
+			dims:   []Dimension{Price},
+			search: "https://www.amazon.com/s?k=lego&rh=p_36%3A1000-1150",
+		},
+		{
+			name:   "nothing offered",
+			dims:   []Dimension{Node, Brand, Rating},
+			search: "https://www.amazon.com/s?k=lego",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Dimensions = test.dims
+			p, err := NewPlanner(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dim, subs := p.Split(test.search, test.links)
+			if dim != test.expectDim || !slices.Equal(subs, test.expected) {
+				t.Errorf("Split() = %q, %v; want %q, %v", dim, subs, test.expectDim, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseDimensions(t *testing.T) {
+	dims, err := ParseDimensions([]string{"node", " price"})
+	if err != nil || !slices.Equal(dims, []Dimension{Node, Price}) {
+		t.Errorf("ParseDimensions() = %v, %v", dims, err)
+	}
+	if _, err := ParseDimensions([]string{"color"}); err == nil {
+		t.Error("expected error for unknown dimension")
+	}
+}
+
+func TestSearchKey(t *testing.T) {
+	tests := map[string]string{
+		"https://www.amazon.com/s?k=lego":                  "https://www.amazon.com/s?k=lego",
+		"https://www.amazon.com/s?k=lego&page=3":           "https://www.amazon.com/s?k=lego",
+		"https://www.amazon.com/s?k=lego&page=2&rh=p_72:1": "https://www.amazon.com/s?k=lego&rh=p_72%3A1",
+	}
+	for search, want := range tests {
+		if got := SearchKey(search); got != want {
+			t.Errorf("SearchKey(%q) = %q; want %q", search, got, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// Marks the url of a removed page as gone, so it isn't retried,
+// and delists its product if tombstones are enabled.
+func (c *crawler) onGone(ctx context.Context, job storage.QueuedURL, err error) {
+	c.log.Info("page is gone", slog.String("url", job.URL))
+
+	if err := c.Storage.MarkGone(ctx, job.URL, err.Error()); err != nil {
+		c.log.Error(err.Error())
+	}
+	// the site answered as expected, it's no reason to slow down
+	c.breaker.Success()
+
+	if !c.Tombstones {
+		return
+	}
+	asin, err := internal.AsinFromURL(job.URL)
+	if err != nil {
+		return // not a product page
+	}
+	err = c.Consumer.Delist(ctx, internal.Tombstone{
+		ASIN:        asin,
+		Marketplace: hostOf(job.URL),
+		URL:         job.URL,
+		GoneAt:      time.Now(),
+	})
+	if err != nil {
+		c.log.Error("failed to delist product", internal.ErrAttr(err), slog.String("asin", asin))
+	}
+}
+
+func isGone(class crawlerr.Class) bool {
+	return class == crawlerr.Gone
+}
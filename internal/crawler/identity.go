@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/proxy"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// The fingerprint the crawler presents to Amazon,
+// made up of the browser's User-Agent and the proxy it connects through.
+type identity struct {
+	id        string
+	userAgent string
+	proxy     proxy.Proxy // drawn from the pool per identity, the browser keeps the proxy of the identity it was launched with
+	startedAt time.Time
+	requests  atomic.Int64
+	blocks    atomic.Int64
+}
+
+// Creates an identity with the next proxy of the pool.
+// The User-Agent is set once the browser of the identity is launched.
+func (c *crawler) newIdentity() *identity {
+	id := &identity{
+		id:        randomID(),
+		startedAt: time.Now(),
+	}
+	if c.Proxies != nil && c.Proxies.Len() > 0 {
+		p, err := c.Proxies.Next(id.id)
+		if err != nil {
+			c.log.Error("failed to get proxy for identity", internal.ErrAttr(err))
+		}
+		id.proxy = p
+	}
+	return id
+}
+
+// Persists the request and block counts of the identity,
+// so the block rates of different fingerprints and proxies can be compared.
+func (c *crawler) saveIdentity(ctx context.Context, id *identity, retired bool) {
+	stats := storage.Identity{
+		ID:        id.id,
+		UserAgent: id.userAgent,
+		Proxy:     id.proxy.Server,
+		StartedAt: id.startedAt,
+		Requests:  int(id.requests.Load()),
+		Blocks:    int(id.blocks.Load()),
+	}
+	if retired {
+		now := time.Now()
+		stats.RetiredAt = &now
+	}
+
+	if err := c.Storage.SaveIdentity(ctx, stats); err != nil {
+		c.log.Error("failed to save identity", internal.ErrAttr(err), slog.String("identity", id.id))
+	}
+}
+
+// Persists the identity as retired and frees its sticky proxy assignment, it's never used again.
+func (c *crawler) retireIdentity(id *identity) {
+	c.saveIdentity(c.workCtx, id, true)
+	if c.Proxies != nil {
+		c.Proxies.Release(id.id)
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,197 @@
+package intercept
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"golang.org/x/net/publicsuffix"
+)
+
+type Action string
+
+const (
+	Block Action = "block"
+	Allow Action = "allow"
+)
+
+// Decides whether a request of a page is sent. All set conditions must match.
+type Rule struct {
+	Name          string   `yaml:"name" json:"name"`
+	Action        Action   `yaml:"action" json:"action"`
+	PageTypes     []string `yaml:"page_types" json:"page_types"`         // types of the page making the request, empty matches all
+	ResourceTypes []string `yaml:"resource_types" json:"resource_types"` // e.g. image, font, xhr, fetch, script
+	URLGlobs      []string `yaml:"url_globs" json:"url_globs"`           // * matches within a path segment, ** across segments
+	URLRegexps    []string `yaml:"url_regexps" json:"url_regexps"`
+	ThirdParty    bool     `yaml:"third_party" json:"third_party"` // only requests to other domains than the page's
+}
+
+// Blocks assets that aren't needed to parse pages, as well as requests of third parties.
+// Requests to Amazon itself, e.g. the xhr of lazily loaded sections, are allowed.
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "assets", Action: Block, ResourceTypes: []string{"stylesheet", "font", "media", "image"}},
+		{Name: "other", Action: Block, ResourceTypes: []string{"other", "ping", "manifest"}},
+		{Name: "third-party-requests", Action: Block, ResourceTypes: []string{"xhr", "fetch", "eventsource", "websocket"}, ThirdParty: true},
+	}
+}
+
+// Reads the rules from a yaml or json file with a top level "rules" list.
+func LoadFile(path string) ([]Rule, error) {
+	var file struct {
+		Rules []Rule `yaml:"rules" json:"rules"`
+	}
+	if err := cleanenv.ReadConfig(path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read interception rules %s: %w", path, err)
+	}
+	return file.Rules, nil
+}
+
+type Interceptor struct {
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	patterns []*regexp.Regexp
+	blocked  atomic.Int64
+	allowed  atomic.Int64
+}
+
+// Creates an interceptor evaluating the rules in order, the first matching rule decides.
+// Requests not matching any rule are allowed.
+func New(rules []Rule) (*Interceptor, error) {
+	var errs []error
+	i := &Interceptor{}
+	for n, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", n+1)
+		}
+		if r.Action != Block && r.Action != Allow {
+			errs = append(errs, fmt.Errorf("rule %s: invalid action %q, expected block or allow", r.Name, r.Action))
+			continue
+		}
+
+		compiled := &rule{Rule: r}
+		for _, glob := range r.URLGlobs {
+			compiled.patterns = append(compiled.patterns, globToRegexp(glob))
+		}
+		for _, expr := range r.URLRegexps {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: invalid url pattern %q: %w", r.Name, expr, err))
+				continue
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		i.rules = append(i.rules, compiled)
+	}
+	return i, errors.Join(errs...)
+}
+
+// Reports whether the request is blocked and counts it for the deciding rule.
+func (i *Interceptor) Block(pageType string, pageURL string, reqURL string, resourceType string) bool {
+	for _, r := range i.rules {
+		if !r.matches(pageType, pageURL, reqURL, resourceType) {
+			continue
+		}
+		if r.Action == Block {
+			r.blocked.Add(1)
+			return true
+		}
+		r.allowed.Add(1)
+		return false
+	}
+	return false
+}
+
+func (r *rule) matches(pageType string, pageURL string, reqURL string, resourceType string) bool {
+	if len(r.PageTypes) > 0 && !slices.Contains(r.PageTypes, pageType) {
+		return false
+	}
+	if len(r.ResourceTypes) > 0 && !slices.Contains(r.ResourceTypes, resourceType) {
+		return false
+	}
+	if len(r.patterns) > 0 && !slices.ContainsFunc(r.patterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(reqURL)
+	}) {
+		return false
+	}
+	if r.ThirdParty && !isThirdParty(pageURL, reqURL) {
+		return false
+	}
+	return true
+}
+
+type RuleStats struct {
+	Action  Action `json:"action"`
+	Blocked int64  `json:"blocked"`
+	Allowed int64  `json:"allowed"`
+}
+
+// Returns how many requests each rule blocked or allowed, keyed by the rule name.
+func (i *Interceptor) Stats() map[string]RuleStats {
+	stats := make(map[string]RuleStats, len(i.rules))
+	for _, r := range i.rules {
+		stats[r.Name] = RuleStats{
+			Action:  r.Action,
+			Blocked: r.blocked.Load(),
+			Allowed: r.allowed.Load(),
+		}
+	}
+	return stats
+}
+
+// Converts a playwright style glob, where * doesn't match "/" and ** matches anything.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Compares the last two labels of the hosts, so www.amazon.com and fls-na.amazon.com are the same party.
+func isThirdParty(pageURL string, reqURL string) bool {
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	req, err := url.Parse(reqURL)
+	if err != nil {
+		return false
+	}
+	return siteOf(page.Hostname()) != siteOf(req.Hostname())
+}
+
+// The registrable domain of the host, e.g. amazon.co.uk for www.amazon.co.uk.
+func siteOf(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host // e.g. a public suffix itself
+	}
+	return site
+}
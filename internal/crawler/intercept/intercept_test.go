@@ -0,0 +1,123 @@
+package intercept
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob     string
+		url      string
+		expected bool
+	}{
+		{"**/*.png", "https://m.media-amazon.com/images/I/a.png", true},
+		{"**/*.png", "https://m.media-amazon.com/images/I/a.png?x=1", false},
+		{"https://www.amazon.com/*/ajax/**", "https://www.amazon.com/gp/ajax/offers?asin=1", true},
+		{"https://www.amazon.com/*/ajax/**", "https://www.amazon.com/gp/x/ajax/offers", false},
+		{"https://www.amazon.com/?", "https://www.amazon.com/s", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.glob+" "+test.url, func(t *testing.T) {
+			if got := globToRegexp(test.glob).MatchString(test.url); got != test.expected {
+				t.Errorf("got %v, want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	rules := append([]Rule{
+		{Name: "offers", Action: Allow, PageTypes: []string{"product"}, ResourceTypes: []string{"xhr"}, URLGlobs: []string{"**/ajax/**"}},
+		{Name: "product-xhr", Action: Block, PageTypes: []string{"product"}, ResourceTypes: []string{"xhr"}},
+	}, DefaultRules()...)
+	i, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := "https://www.amazon.com/dp/B08N5WRWNW"
+	tests := []struct {
+		name         string
+		pageType     string
+		reqURL       string
+		resourceType string
+		expected     bool
+	}{
+		{"allowed xhr endpoint", "product", "https://www.amazon.com/gp/ajax/offers", "xhr", false},
+		{"other product xhr", "product", "https://www.amazon.com/gp/uedata", "xhr", true},
+		{"first party xhr of search", "search", "https://www.amazon.com/gp/uedata", "xhr", false},
+		{"third party xhr", "search", "https://tracker.example.com/collect", "xhr", true},
+		{"subdomain isn't third party", "search", "https://fls-na.amazon.com/1/batch", "fetch", false},
+		{"image", "search", "https://m.media-amazon.com/images/I/a.jpg", "image", true},
+		{"document", "other", page, "document", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := i.Block(test.pageType, page, test.reqURL, test.resourceType); got != test.expected {
+				t.Errorf("got %v, want %v", got, test.expected)
+			}
+		})
+	}
+
+	stats := i.Stats()
+	if stats["offers"].Allowed != 1 || stats["product-xhr"].Blocked != 1 || stats["third-party-requests"].Blocked != 1 || stats["assets"].Blocked != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New([]Rule{{Action: "drop"}}); err == nil {
+		t.Error("expected error for invalid action")
+	}
+	if _, err := New([]Rule{{Action: Block, URLRegexps: []string{"("}}}); err == nil {
+		t.Error("expected error for invalid regexp")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(path, []byte(`
+rules:
+  - name: lazy-sections
+    action: allow
+    page_types: [product]
+    resource_types: [xhr, fetch]
+    url_regexps: ['/(hz|gp)/.*ajax']
+  - name: trackers
+    action: block
+    third_party: true
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Action != Allow || rules[0].PageTypes[0] != "product" || !rules[1].ThirdParty {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+	if _, err := New(rules); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSiteOf(t *testing.T) {
+	tests := map[string]string{
+		"www.amazon.com":        "amazon.com",
+		"fls-eu.amazon.co.uk":   "amazon.co.uk",
+		"tracker.example.co.uk": "example.co.uk",
+		"amazon.de":             "amazon.de",
+		"127.0.0.1":             "127.0.0.1",
+	}
+	for host, want := range tests {
+		if got := siteOf(host); got != want {
+			t.Errorf("%s: got %s, want %s", host, got, want)
+		}
+	}
+}
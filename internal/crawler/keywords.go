@@ -0,0 +1,35 @@
+package crawler
+
+import (
+	"fmt"
+
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// Queues the searches of the keywords on every marketplace, tagged with their keyword.
+// They are queued in storage like found links, so instances started later don't repeat them.
+func (c *crawler) queueKeywords() {
+	if len(c.Keywords) == 0 {
+		return
+	}
+
+	var links []storage.Link
+	for _, k := range c.Keywords {
+		for _, url := range k.URLs(c.Marketplaces) {
+			link := storage.Link{
+				URL:     url,
+				Seed:    url,
+				Context: storage.LinkSeed,
+				Tag:     k.Tag,
+			}
+			link.Priority = c.LinkPriority(link)
+			links = append(links, link)
+		}
+	}
+
+	added, err := c.Storage.AddURLs(c.ctx, links)
+	if err != nil {
+		c.log.Error(err.Error())
+	}
+	c.log.Info(fmt.Sprintf("queued %d of %d keyword searches", len(added), len(links)))
+}
@@ -0,0 +1,256 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/camoufox"
+	"github.com/jonashiltl/amazon-crawler/internal/proxy"
+	"github.com/playwright-community/playwright-go"
+	"github.com/subsan/uafaker"
+)
+
+// Starts the browsers the crawler fetches pages with.
+// A new browser is launched on every restart.
+type BrowserLauncher interface {
+	// Launches a browser that connects through the proxy, if its Server is set.
+	Launch(pw *playwright.Playwright, p proxy.Proxy) (*Browser, error)
+}
+
+// A browser started by a BrowserLauncher.
+type Browser struct {
+	playwright.Browser
+	UserAgent string // the spoofed User-Agent, empty if the browser presents its own
+	Pid       int    // process group of the browser to check its memory, 0 if unknown
+	Stop      func() // stops the processes started for the browser, nil if there are none
+}
+
+// Implemented by launchers that spoof the User-Agent,
+// so an identity can get a new one without restarting the browser.
+type userAgentRotator interface {
+	randomUserAgent() string
+}
+
+// Launches Camoufox with a new fingerprint in a virtual display and connects to it.
+func NewCamoufoxLauncher(opts camoufox.LaunchOptions) BrowserLauncher {
+	return &camoufoxLauncher{opts: opts}
+}
+
+type camoufoxLauncher struct {
+	opts camoufox.LaunchOptions
+}
+
+func (l *camoufoxLauncher) Launch(pw *playwright.Playwright, p proxy.Proxy) (*Browser, error) {
+	slog.Info("starting Camoufox browser")
+
+	opts := l.opts
+	opts.UserAgent = l.randomUserAgent()
+	if opts.Port == 0 {
+		// a free port per launch allows several instances per host
+		port, err := camoufox.FreePort()
+		if err != nil {
+			return nil, err
+		}
+		opts.Port = port
+	}
+	if p.Server != "" {
+		slog.Info(fmt.Sprintf("using proxy %s", p.Server))
+		opts.Proxy = &camoufox.Proxy{
+			Server:   p.Server,
+			Username: p.Username,
+			Password: p.Password,
+		}
+	}
+
+	slog.Info(fmt.Sprintf("using User-Agent %s", opts.UserAgent))
+
+	code, err := opts.Script()
+	if err != nil {
+		return nil, err
+	}
+	if err := camoufox.ValidateScript(code); err != nil {
+		return nil, err
+	}
+
+	// use xvfb so that webgl is supported in docker container
+	cmd := exec.Command("xvfb-run", "-a", "-e", "/dev/stdout", "python3", "-c", code)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// own process group, so stopping also kills Xvfb and the browser started by xvfb-run
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start Camoufox: %w", err)
+	}
+
+	proc := &camoufoxProcess{
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		close(proc.exited)
+		if proc.stopping.Load() {
+			return
+		}
+		// the browser disconnects as well, which lets the supervisor restart it
+		if err != nil {
+			slog.Error("Camoufox exited with error", internal.ErrAttr(err))
+		} else {
+			slog.Warn("Camoufox exited cleanly")
+		}
+	}()
+
+	browser, err := connectBrowser(pw.Firefox, opts.WSURL())
+	if err != nil {
+		proc.stop()
+		return nil, err
+	}
+
+	return &Browser{
+		Browser:   browser,
+		UserAgent: opts.UserAgent,
+		Pid:       cmd.Process.Pid,
+		Stop:      proc.stop,
+	}, nil
+}
+
+// Returns a Firefox User-Agent of the operating system Camoufox spoofs,
+// so the User-Agent matches the rest of the fingerprint.
+func (l *camoufoxLauncher) randomUserAgent() string {
+	switch l.opts.OS {
+	case "macos":
+		return uafaker.MacOS().Firefox().Random()
+	case "linux":
+		return uafaker.Linux().Firefox().Random()
+	default:
+		return uafaker.Windows().Firefox().Random()
+	}
+}
+
+type camoufoxProcess struct {
+	cmd      *exec.Cmd
+	stopping atomic.Bool // set before an intentional stop, so the exit isn't logged as crash
+	exited   chan struct{}
+}
+
+// Terminates the process group and waits for it to exit.
+func (p *camoufoxProcess) stop() {
+	p.stopping.Store(true)
+	syscall.Kill(-p.cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-p.exited:
+	case <-time.After(10 * time.Second):
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+		<-p.exited
+	}
+}
+
+// Launches the browser bundled with playwright, without any fingerprint spoofing.
+// Meant for development and tests against local pages, where Camoufox isn't needed.
+func NewPlaywrightLauncher(engine string, headless bool) (BrowserLauncher, error) {
+	if _, err := browserType(&playwright.Playwright{}, engine); err != nil {
+		return nil, err
+	}
+	return &playwrightLauncher{engine: engine, headless: headless}, nil
+}
+
+type playwrightLauncher struct {
+	engine   string
+	headless bool
+}
+
+func (l *playwrightLauncher) Launch(pw *playwright.Playwright, p proxy.Proxy) (*Browser, error) {
+	slog.Info(fmt.Sprintf("launching %s browser", l.engine))
+
+	bt, err := browserType(pw, l.engine)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := playwright.BrowserTypeLaunchOptions{
+		Headless: playwright.Bool(l.headless),
+	}
+	if p.Server != "" {
+		slog.Info(fmt.Sprintf("using proxy %s", p.Server))
+		pp := p.Playwright()
+		opts.Proxy = &pp
+	}
+
+	browser, err := bt.Launch(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to launch %s: %w", l.engine, err)
+	}
+	return &Browser{Browser: browser}, nil
+}
+
+// Connects to a browser server that is managed elsewhere, e.g. a Camoufox container.
+// The browser isn't stopped when the crawler restarts or closes it, only disconnected.
+func NewRemoteLauncher(engine string, wsURL string) (BrowserLauncher, error) {
+	if _, err := browserType(&playwright.Playwright{}, engine); err != nil {
+		return nil, err
+	}
+	if wsURL == "" {
+		return nil, fmt.Errorf("missing websocket endpoint of remote browser")
+	}
+	return &remoteLauncher{engine: engine, wsURL: wsURL}, nil
+}
+
+type remoteLauncher struct {
+	engine string
+	wsURL  string
+}
+
+func (l *remoteLauncher) Launch(pw *playwright.Playwright, p proxy.Proxy) (*Browser, error) {
+	if p.Server != "" {
+		slog.Warn("the proxy of a remote browser can't be changed, only browser contexts use the proxy pool")
+	}
+
+	bt, err := browserType(pw, l.engine)
+	if err != nil {
+		return nil, err
+	}
+
+	browser, err := connectBrowser(bt, l.wsURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Browser{Browser: browser}, nil
+}
+
+func browserType(pw *playwright.Playwright, engine string) (playwright.BrowserType, error) {
+	switch engine {
+	case "firefox":
+		return pw.Firefox, nil
+	case "chromium":
+		return pw.Chromium, nil
+	default:
+		return nil, fmt.Errorf("unsupported browser engine %q, expected firefox or chromium", engine)
+	}
+}
+
+// Connects to the browser server, retrying while it's still starting.
+func connectBrowser(bt playwright.BrowserType, wsURL string) (playwright.Browser, error) {
+	attempts := 5
+	sleep := time.Second * 2
+	for i := range attempts {
+		if i > 0 {
+			time.Sleep(sleep)
+			sleep *= 2
+		}
+		browser, err := bt.Connect(wsURL)
+		if err != nil {
+			continue
+		}
+		slog.Info(fmt.Sprintf("connected to browser at %s", wsURL))
+		return browser, nil
+	}
+
+	return nil, fmt.Errorf("failed to connect to %s", wsURL)
+}
@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Picks the delivery location of a new session, spreading the sessions evenly over the locations.
+// Returns an empty location if none are configured.
+func (c *crawler) nextLocation() string {
+	if len(c.Locations) == 0 {
+		return ""
+	}
+	i := c.locationIdx.Add(1) - 1
+	return c.Locations[i%uint64(len(c.Locations))]
+}
+
+// Makes sure the session uses one of the configured delivery locations on the marketplace of the url.
+// Every marketplace keeps its own location, as the cookies are per host.
+// Restored sessions keep their locations, as long as they're still configured.
+func (c *crawler) ensureLocation(ctx context.Context, s *session, url string) error {
+	marketplace := hostOf(url)
+	if len(c.Locations) == 0 || slices.Contains(c.Locations, s.locations[marketplace]) {
+		return nil
+	}
+	location := c.nextLocation()
+	if err := setLocation(ctx, s.context, baseURLOf(url), location); err != nil {
+		return err
+	}
+	c.log.Debug("set delivery location", slog.String("session", s.id), slog.String("marketplace", marketplace), slog.String("location", location))
+	if s.locations == nil {
+		s.locations = make(map[string]string)
+	}
+	s.locations[marketplace] = location
+	return nil
+}
+
+// Sets the delivery ZIP code through the location popover ("glow") in the navigation bar of the marketplace.
+// Amazon stores the location with the session cookies, so it applies to all later pages of the marketplace.
+func setLocation(ctx context.Context, browserContext playwright.BrowserContext, baseURL string, zip string) error {
+	page, err := browserContext.NewPage()
+	if err != nil {
+		return err
+	}
+	defer page.Close()
+	page.SetDefaultTimeout(10 * 1000) // 10 seconds
+
+	// playwright calls don't take a context, closing the page aborts the pending one
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			page.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := page.Goto(baseURL, playwright.PageGotoOptions{
+		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+	}); err != nil {
+		return fmt.Errorf("failed to open location popover: %w", err)
+	}
+
+	if err := page.Locator("#nav-global-location-popover-link").Click(); err != nil {
+		return fmt.Errorf("failed to open location popover: %w", err)
+	}
+	if err := page.Locator("#GLUXZipUpdateInput").Fill(zip); err != nil {
+		return fmt.Errorf("failed to enter zip code: %w", err)
+	}
+	if err := page.Locator("#GLUXZipUpdate").Click(); err != nil {
+		return fmt.Errorf("failed to submit zip code: %w", err)
+	}
+
+	// the popover asks to reload the page once the address changed
+	confirm := page.Locator(`#GLUXConfirmClose, .a-popover-footer input[name="glowDoneButton"]`).First()
+	if err := confirm.Click(); err == nil {
+		page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
+			State: playwright.LoadStateDomcontentloaded,
+		})
+	}
+
+	if _, err := page.Reload(playwright.PageReloadOptions{
+		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+	}); err != nil {
+		return err
+	}
+	deliverTo, err := page.Locator("#glow-ingress-line2").TextContent()
+	if err != nil {
+		return fmt.Errorf("failed to read delivery location: %w", err)
+	}
+	if !strings.Contains(deliverTo, zip) {
+		return fmt.Errorf("delivery location is %q instead of %s", strings.TrimSpace(deliverTo), zip)
+	}
+	return nil
+}
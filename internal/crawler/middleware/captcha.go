@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
 	playwright "github.com/playwright-community/playwright-go"
 )
 
@@ -13,10 +15,9 @@ func NewCaptchaMiddleware() ResponseMiddleware {
 	return captchaMiddleware{}
 }
 
-func (j captchaMiddleware) Process(ctx context.Context, url string, page playwright.Page, res playwright.Response) error {
-	visible, err := page.Locator("input#captchacharacters,div#challenge-container").IsVisible()
-	if visible && err == nil {
-		return errors.New("blocked with captcha")
+func (j captchaMiddleware) Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error {
+	if pageType == classify.Captcha {
+		return crawlerr.New(crawlerr.Captcha, errors.New("blocked with captcha"))
 	}
 	return nil
 }
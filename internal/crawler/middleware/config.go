@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// The configurable request middlewares, read from a yaml or json file.
+type Config struct {
+	Headers     []HeaderRule      `yaml:"headers" json:"headers"`
+	Cookies     []CookieRule      `yaml:"cookies" json:"cookies"`
+	Geolocation []GeolocationRule `yaml:"geolocation" json:"geolocation"`
+	Timezone    string            `yaml:"timezone" json:"timezone"` // IANA name, e.g. America/New_York. Applies to whole browser contexts.
+}
+
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to read middleware config %s: %w", path, err)
+	}
+	if cfg.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			return cfg, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Creates the middlewares of the config, executed in the order headers, cookies, geolocation.
+func (cfg Config) RequestMiddlewares() ([]RequestMiddleware, error) {
+	var mws []RequestMiddleware
+	if len(cfg.Headers) > 0 {
+		mw, err := NewHeadersMiddleware(cfg.Headers)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, mw)
+	}
+	if len(cfg.Cookies) > 0 {
+		mw, err := NewCookiesMiddleware(cfg.Cookies)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, mw)
+	}
+	if len(cfg.Geolocation) > 0 {
+		mw, err := NewGeolocationMiddleware(cfg.Geolocation)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, mw)
+	}
+	return mws, nil
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type CookieRule struct {
+	URLs    []string `yaml:"urls" json:"urls"` // regular expressions, empty matches every url
+	Cookies []Cookie `yaml:"cookies" json:"cookies"`
+}
+
+type Cookie struct {
+	Name   string `yaml:"name" json:"name"`
+	Value  string `yaml:"value" json:"value"`
+	Domain string `yaml:"domain" json:"domain"` // e.g. .amazon.com
+	Path   string `yaml:"path" json:"path"`     // defaults to /
+	URL    string `yaml:"url" json:"url"`       // alternative to domain and path, e.g. https://www.amazon.com
+}
+
+// The domain the browser stores the cookie under.
+func (c Cookie) domain() string {
+	if c.Domain != "" {
+		return c.Domain
+	}
+	u, _ := neturl.Parse(c.URL)
+	return u.Hostname()
+}
+
+func (c Cookie) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("cookie without a name")
+	}
+	if c.Domain == "" && c.URL == "" {
+		return fmt.Errorf("cookie %s needs a domain or url", c.Name)
+	}
+	if c.Domain != "" && c.URL != "" {
+		return fmt.Errorf("cookie %s has both a domain and url", c.Name)
+	}
+	if c.URL != "" {
+		if u, err := neturl.Parse(c.URL); err != nil || u.Hostname() == "" {
+			return fmt.Errorf("cookie %s has an invalid url %q", c.Name, c.URL)
+		}
+	}
+	return nil
+}
+
+type cookiesMiddleware struct {
+	rules    []CookieRule
+	matchers []URLMatcher
+	changes  sync.Map // page -> cookieChange, until the page is restored
+}
+
+// The cookies set for a page and the cookies of the session they replaced.
+type cookieChange struct {
+	added    []Cookie
+	replaced []playwright.OptionalCookie
+}
+
+// Adds cookies to the browser context before the url is requested,
+// e.g. i18n-prefs to pick the currency or lc-main to pick the language.
+// Cookies of the session with the same name are overwritten until the page is done.
+func NewCookiesMiddleware(rules []CookieRule) (RequestMiddleware, error) {
+	matchers := make([]URLMatcher, 0, len(rules))
+	for _, rule := range rules {
+		for _, cookie := range rule.Cookies {
+			if err := cookie.validate(); err != nil {
+				return nil, err
+			}
+		}
+		m, err := NewURLMatcher(rule.URLs)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return &cookiesMiddleware{rules: rules, matchers: matchers}, nil
+}
+
+func (c *cookiesMiddleware) Process(ctx context.Context, url string, page playwright.Page) error {
+	var added []Cookie
+	var cookies []playwright.OptionalCookie
+	for i, rule := range c.rules {
+		if !c.matchers[i].Match(url) {
+			continue
+		}
+		for _, cookie := range rule.Cookies {
+			added = append(added, cookie)
+			cookies = append(cookies, optionalCookie(cookie))
+		}
+	}
+	if len(cookies) == 0 {
+		return nil
+	}
+
+	bctx := page.Context()
+	current, err := bctx.Cookies()
+	if err != nil {
+		return err
+	}
+	change := cookieChange{added: added}
+	for _, existing := range current {
+		for _, cookie := range added {
+			if existing.Name == cookie.Name && existing.Domain == cookie.domain() {
+				change.replaced = append(change.replaced, existing.ToOptionalCookie())
+				break
+			}
+		}
+	}
+	c.changes.Store(page, change)
+
+	return bctx.AddCookies(cookies)
+}
+
+// Removes the added cookies and puts back the replaced ones,
+// the browser context is reused for urls the rules don't match.
+func (c *cookiesMiddleware) Restore(url string, page playwright.Page) error {
+	v, ok := c.changes.LoadAndDelete(page)
+	if !ok {
+		return nil
+	}
+	change := v.(cookieChange)
+
+	bctx := page.Context()
+	for _, cookie := range change.added {
+		err := bctx.ClearCookies(playwright.BrowserContextClearCookiesOptions{
+			Name:   cookie.Name,
+			Domain: cookie.domain(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(change.replaced) == 0 {
+		return nil
+	}
+	return bctx.AddCookies(change.replaced)
+}
+
+func optionalCookie(cookie Cookie) playwright.OptionalCookie {
+	if cookie.URL != "" {
+		return playwright.OptionalCookie{
+			Name:  cookie.Name,
+			Value: cookie.Value,
+			URL:   playwright.String(cookie.URL),
+		}
+	}
+	path := cookie.Path
+	if path == "" {
+		path = "/"
+	}
+	return playwright.OptionalCookie{
+		Name:   cookie.Name,
+		Value:  cookie.Value,
+		Domain: playwright.String(cookie.Domain),
+		Path:   playwright.String(path),
+	}
+}
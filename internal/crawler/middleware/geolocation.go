@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type GeolocationRule struct {
+	URLs      []string `yaml:"urls" json:"urls"` // regular expressions, empty matches every url
+	Latitude  float64  `yaml:"latitude" json:"latitude"`
+	Longitude float64  `yaml:"longitude" json:"longitude"`
+	Accuracy  float64  `yaml:"accuracy" json:"accuracy"` // in meters
+}
+
+type geolocationMiddleware struct {
+	rules    []GeolocationRule
+	matchers []URLMatcher
+}
+
+// Grants the geolocation permission and reports the position of the first matching rule.
+func NewGeolocationMiddleware(rules []GeolocationRule) (RequestMiddleware, error) {
+	matchers := make([]URLMatcher, 0, len(rules))
+	for _, rule := range rules {
+		m, err := NewURLMatcher(rule.URLs)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return &geolocationMiddleware{rules: rules, matchers: matchers}, nil
+}
+
+func (g *geolocationMiddleware) Process(ctx context.Context, url string, page playwright.Page) error {
+	rule, ok := g.match(url)
+	if !ok {
+		return nil
+	}
+	bctx := page.Context()
+	if err := bctx.GrantPermissions([]string{"geolocation"}); err != nil {
+		return err
+	}
+	return bctx.SetGeolocation(&playwright.Geolocation{
+		Latitude:  rule.Latitude,
+		Longitude: rule.Longitude,
+		Accuracy:  playwright.Float(rule.Accuracy),
+	})
+}
+
+// Revokes the permission and position again, the browser context is reused for urls the rules don't match.
+func (g *geolocationMiddleware) Restore(url string, page playwright.Page) error {
+	if _, ok := g.match(url); !ok {
+		return nil
+	}
+	bctx := page.Context()
+	if err := bctx.SetGeolocation(nil); err != nil {
+		return err
+	}
+	return bctx.ClearPermissions()
+}
+
+func (g *geolocationMiddleware) match(url string) (GeolocationRule, bool) {
+	for i, rule := range g.rules {
+		if g.matchers[i].Match(url) {
+			return rule, true
+		}
+	}
+	return GeolocationRule{}, false
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"maps"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type HeaderRule struct {
+	URLs    []string          `yaml:"urls" json:"urls"` // regular expressions, empty matches every url
+	Headers map[string]string `yaml:"headers" json:"headers"`
+}
+
+type headersMiddleware struct {
+	rules    []HeaderRule
+	matchers []URLMatcher
+}
+
+// Sends additional HTTP headers, e.g. Accept-Language, with every request of the page.
+// Headers of later rules override those of earlier rules.
+func NewHeadersMiddleware(rules []HeaderRule) (RequestMiddleware, error) {
+	matchers := make([]URLMatcher, 0, len(rules))
+	for _, rule := range rules {
+		m, err := NewURLMatcher(rule.URLs)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return &headersMiddleware{rules: rules, matchers: matchers}, nil
+}
+
+func (h *headersMiddleware) Process(ctx context.Context, url string, page playwright.Page) error {
+	headers := h.headers(url)
+	if len(headers) == 0 {
+		return nil
+	}
+	return page.SetExtraHTTPHeaders(headers)
+}
+
+func (h *headersMiddleware) headers(url string) map[string]string {
+	headers := map[string]string{}
+	for i, rule := range h.rules {
+		if h.matchers[i].Match(url) {
+			maps.Copy(headers, rule.Headers)
+		}
+	}
+	return headers
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
 	playwright "github.com/playwright-community/playwright-go"
 )
 
@@ -13,10 +15,10 @@ func NewJSDisabledMiddleware() ResponseMiddleware {
 	return jsDisabledMiddleware{}
 }
 
-func (j jsDisabledMiddleware) Process(ctx context.Context, url string, page playwright.Page, res playwright.Response) error {
+func (j jsDisabledMiddleware) Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error {
 	visible, err := page.Locator("noscript:has-text(\"javascript is disabled\")").IsVisible()
 	if visible && err == nil {
-		return errors.New("js is disabled")
+		return crawlerr.New(crawlerr.JSDisabled, errors.New("js is disabled"))
 	}
 	return nil
 }
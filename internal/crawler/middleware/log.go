@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
 	playwright "github.com/playwright-community/playwright-go"
 )
 
@@ -13,7 +14,7 @@ func NewLogMiddleware() ResponseMiddleware {
 	return logMiddleware{}
 }
 
-func (j logMiddleware) Process(ctx context.Context, url string, page playwright.Page, res playwright.Response) error {
-	slog.Info(url, slog.Int("status", res.Status()))
+func (j logMiddleware) Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error {
+	slog.Info(url, slog.Int("status", res.Status()), slog.String("type", string(pageType)))
 	return nil
 }
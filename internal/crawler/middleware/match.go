@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Restricts a middleware to the urls matching any of its patterns.
+// Without patterns every url matches.
+type URLMatcher []*regexp.Regexp
+
+func NewURLMatcher(patterns []string) (URLMatcher, error) {
+	m := make(URLMatcher, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url pattern %q: %w", p, err)
+		}
+		m = append(m, re)
+	}
+	return m, nil
+}
+
+func (m URLMatcher) Match(url string) bool {
+	if len(m) == 0 {
+		return true
+	}
+	for _, re := range m {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
 	"github.com/playwright-community/playwright-go"
 )
 
@@ -11,7 +12,13 @@ type RequestMiddleware interface {
 	Process(ctx context.Context, url string, page playwright.Page) error
 }
 
-// Called after the url is requested
+// Implemented by request middlewares that change the browser context of the page.
+// Contexts are reused for later urls, so the change is undone once the page is done.
+type Restorer interface {
+	Restore(url string, page playwright.Page) error
+}
+
+// Called after the url is requested, with the type of the loaded page.
 type ResponseMiddleware interface {
-	Process(ctx context.Context, url string, page playwright.Page, res playwright.Response) error
+	Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error
 }
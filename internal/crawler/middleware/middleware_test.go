@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLMatcher(t *testing.T) {
+	m, err := NewURLMatcher([]string{`/dp/`, `[?&]k=`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{"https://www.amazon.com/dp/B08N5WRWNW", true},
+		{"https://www.amazon.com/s?k=lego", true},
+		{"https://www.amazon.com/b?node=123", false},
+	}
+	for _, test := range tests {
+		if got := m.Match(test.url); got != test.expected {
+			t.Errorf("Match(%q) = %v; want %v", test.url, got, test.expected)
+		}
+	}
+
+	if !URLMatcher(nil).Match("https://www.amazon.com/b?node=123") {
+		t.Error("matcher without patterns must match every url")
+	}
+	if _, err := NewURLMatcher([]string{"("}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	mw, err := NewHeadersMiddleware([]HeaderRule{
+		{Headers: map[string]string{"Accept-Language": "en-US,en;q=0.9", "X-Test": "all"}},
+		{URLs: []string{`/dp/`}, Headers: map[string]string{"X-Test": "product"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw.(*headersMiddleware)
+
+	got := h.headers("https://www.amazon.com/dp/B08N5WRWNW")
+	want := map[string]string{"Accept-Language": "en-US,en;q=0.9", "X-Test": "product"}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got = h.headers("https://www.amazon.com/s?k=lego")
+	want = map[string]string{"Accept-Language": "en-US,en;q=0.9", "X-Test": "all"}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "middlewares.yaml")
+	err := os.WriteFile(path, []byte(`
+headers:
+  - headers:
+      Accept-Language: en-US,en;q=0.9
+cookies:
+  - urls: ["amazon\\.com"]
+    cookies:
+      - name: i18n-prefs
+        value: USD
+        domain: .amazon.com
+geolocation:
+  - latitude: 40.75
+    longitude: -73.99
+    accuracy: 100
+timezone: America/New_York
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timezone != "America/New_York" || len(cfg.Cookies) != 1 || cfg.Cookies[0].Cookies[0].Value != "USD" || cfg.Geolocation[0].Longitude != -73.99 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	mws, err := cfg.RequestMiddlewares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mws) != 3 {
+		t.Errorf("got %d middlewares, want 3", len(mws))
+	}
+
+	if err := os.WriteFile(path, []byte("timezone: Mars/Olympus\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected error for invalid timezone")
+	}
+}
+
+func TestCookieValidation(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		valid  bool
+	}{
+		{Cookie{Name: "i18n-prefs", Value: "USD", Domain: ".amazon.com"}, true},
+		{Cookie{Name: "i18n-prefs", Value: "EUR", URL: "https://www.amazon.de"}, true},
+		{Cookie{Name: "i18n-prefs", Value: "USD"}, false},
+		{Cookie{Name: "i18n-prefs", Value: "USD", Domain: ".amazon.com", URL: "https://www.amazon.com"}, false},
+		{Cookie{Name: "i18n-prefs", Value: "USD", URL: "amazon"}, false},
+		{Cookie{Value: "USD", Domain: ".amazon.com"}, false},
+	}
+	for _, tt := range tests {
+		_, err := NewCookiesMiddleware([]CookieRule{{Cookies: []Cookie{tt.cookie}}})
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: got error %v, want valid %t", tt.cookie, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+	playwright "github.com/playwright-community/playwright-go"
+)
+
+type notFoundMiddleware struct{}
+
+// Detects the "dog page" Amazon shows for removed pages, which is served
+// with a 404 but also with a 200 status.
+func NewNotFoundMiddleware() ResponseMiddleware {
+	return notFoundMiddleware{}
+}
+
+func (n notFoundMiddleware) Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error {
+	if pageType == classify.NotFound {
+		return crawlerr.New(crawlerr.Gone, errors.New("page not found"))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
 	"github.com/jonashiltl/amazon-crawler/internal/polite"
 	"github.com/playwright-community/playwright-go"
 )
@@ -14,11 +15,7 @@ type robotsMiddleware struct {
 
 // Detects whether the request is forbidden by the pages robots.txt
 func NewRobotsMiddleware(opts polite.Options) RequestMiddleware {
-	robots := polite.NewRobotsChecker(polite.Options{
-		Proxy:     opts.Proxy,
-		ProxyPW:   opts.ProxyPW,
-		ProxyUser: opts.ProxyUser,
-	})
+	robots := polite.NewRobotsChecker(opts)
 
 	return &robotsMiddleware{
 		Options: opts,
@@ -39,7 +36,7 @@ func (r *robotsMiddleware) Process(ctx context.Context, url string, page playwri
 
 	err = r.robots.Check(url, ua)
 	if err != nil {
-		return err
+		return crawlerr.New(crawlerr.RobotsForbidden, err)
 	}
 	return nil
 }
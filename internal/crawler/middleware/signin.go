@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+	playwright "github.com/playwright-community/playwright-go"
+)
+
+type signInMiddleware struct{}
+
+// Fails pages redirected to the sign-in form, e.g. review lists, which the crawler can't see.
+func NewSignInMiddleware() ResponseMiddleware {
+	return signInMiddleware{}
+}
+
+func (s signInMiddleware) Process(ctx context.Context, url string, pageType classify.PageType, page playwright.Page, res playwright.Response) error {
+	if pageType == classify.SignIn {
+		return crawlerr.New(crawlerr.SignIn, errors.New("page requires sign-in"))
+	}
+	return nil
+}
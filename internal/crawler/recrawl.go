@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/recrawl"
+)
+
+// Records the visit of the product page and schedules its next visit,
+// sooner for products that changed often in past visits.
+func (c *crawler) scheduleRevisit(ctx context.Context, url string, product internal.Product) {
+	if c.Recrawl == nil {
+		return
+	}
+
+	visits, err := c.Storage.RecordVisit(ctx, url, recrawl.Fingerprint(product))
+	if err != nil {
+		c.log.Error(err.Error())
+		return
+	}
+	interval := c.Recrawl.Interval(product.Categories, recrawl.History{
+		Visits:   visits.Count,
+		Changes:  visits.Changes,
+		Observed: visits.Last.Sub(visits.First),
+	})
+	if err := c.Storage.ScheduleRevisit(ctx, url, visits.Last.Add(interval)); err != nil {
+		c.log.Error(err.Error())
+		return
+	}
+	c.log.Debug("scheduled revisit", slog.String("url", url), slog.Duration("in", interval), slog.Int("visits", visits.Count), slog.Int("changes", visits.Changes))
+}
+
+// Periodically queues the product pages whose revisit is due.
+func (c *crawler) startRecrawler() {
+	if c.Recrawl == nil {
+		return
+	}
+	ticker := time.NewTicker(c.RecrawlCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := c.Storage.RequeueDue(c.ctx)
+				if err != nil {
+					c.log.Error(err.Error())
+					continue
+				}
+				if n > 0 {
+					c.log.Info(fmt.Sprintf("queued %d urls for revisiting", n))
+				}
+			}
+		}
+	}()
+}
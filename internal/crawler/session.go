@@ -0,0 +1,374 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/proxy"
+	"github.com/playwright-community/playwright-go"
+)
+
+type SessionOptions struct {
+	Size        int           // maximum number of browser contexts kept alive, defaults to the number of workers
+	MaxAge      time.Duration // sessions are retired once they are older, 0 disables
+	MaxRequests int           // sessions are retired after this many pages, 1 uses a fresh context per page
+	Dir         string        // storage states are saved to this directory to survive restarts, empty disables
+}
+
+// A browser context reused for many pages, so the crawler looks like a returning
+// visitor with cookies instead of a new visitor on every page.
+type session struct {
+	id        string
+	context   playwright.BrowserContext
+	proxy     *proxy.Proxy      // nil if the browser's own proxy is used
+	locations map[string]string // delivery ZIP code per marketplace, none if Amazon derives it from the ip
+	createdAt time.Time
+	requests  int
+}
+
+func (s *session) expired(opts SessionOptions) bool {
+	if opts.MaxRequests > 0 && s.requests >= opts.MaxRequests {
+		return true
+	}
+	return opts.MaxAge > 0 && time.Since(s.createdAt) >= opts.MaxAge
+}
+
+// Bounds the number of live sessions and keeps the idle ones for reuse.
+// Sessions belong to the running browser, so the pool is emptied before it's replaced.
+type sessionPool struct {
+	opts  SessionOptions
+	slots chan struct{} // one per live session in use
+	mu    sync.Mutex
+	idle  []*session
+	store *sessionStore
+}
+
+func newSessionPool(opts SessionOptions) (*sessionPool, error) {
+	store, err := newSessionStore(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionPool{
+		opts:  opts,
+		slots: make(chan struct{}, opts.Size),
+		store: store,
+	}, nil
+}
+
+// Returns an idle session or creates a new one, blocking while all sessions are in use.
+// must be called with browserMu held for reading
+func (c *crawler) acquireSession(ctx context.Context) (*session, error) {
+	pool := c.sessions
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		pool.mu.Lock()
+		if len(pool.idle) == 0 {
+			pool.mu.Unlock()
+			break
+		}
+		s := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		pool.mu.Unlock()
+
+		if !s.expired(pool.opts) {
+			return s, nil
+		}
+		c.retireSession(s, "expired")
+	}
+
+	s, err := c.newSession()
+	if err != nil {
+		<-pool.slots
+		return nil, err
+	}
+	return s, nil
+}
+
+// Returns the session to the pool, or closes it if it's expired or a reason to retire it is given.
+// must be called with browserMu held for reading
+func (c *crawler) releaseSession(s *session, retireReason string) {
+	pool := c.sessions
+	defer func() { <-pool.slots }()
+
+	s.requests++
+	if retireReason != "" {
+		c.retireSession(s, retireReason)
+		return
+	}
+	if s.expired(pool.opts) {
+		c.retireSession(s, "expired")
+		return
+	}
+
+	// saved after every page, so a crash loses at most the last cookies
+	c.saveSession(s)
+
+	pool.mu.Lock()
+	pool.idle = append(pool.idle, s)
+	pool.mu.Unlock()
+}
+
+// Creates a browser context, continuing a saved session if there is one.
+func (c *crawler) newSession() (*session, error) {
+	s := &session{
+		id:        randomID(),
+		createdAt: time.Now(),
+	}
+
+	var contextOpts playwright.BrowserNewContextOptions
+	if c.Timezone != "" {
+		// browsers fix the timezone when the context is created
+		contextOpts.TimezoneId = playwright.String(c.Timezone)
+	}
+	if saved := c.sessions.store.take(); saved != nil {
+		s.id = saved.ID
+		s.createdAt = saved.CreatedAt
+		s.requests = saved.Requests
+		s.locations = saved.Locations
+		contextOpts.StorageState = saved.State.optional()
+	}
+
+	// the session id keeps sticky proxies with the session, cookies are often bound to the ip
+	if c.Proxies != nil && c.Proxies.Len() > 0 {
+		p, err := c.Proxies.Next(s.id)
+		if err != nil {
+			c.sessions.store.giveBack(s.id)
+			return nil, err
+		}
+		pp := p.Playwright()
+		contextOpts.Proxy = &pp
+		s.proxy = &p
+	}
+	// a rotated identity presents another User-Agent than the browser was launched with
+	if c.identity.userAgent != "" && c.identity.userAgent != c.browser.UserAgent {
+		contextOpts.UserAgent = playwright.String(c.identity.userAgent)
+	}
+
+	context, err := c.browser.NewContext(contextOpts)
+	if err != nil {
+		c.sessions.store.giveBack(s.id)
+		return nil, err
+	}
+	s.context = context
+
+	c.log.Debug("created session", slog.String("session", s.id), slog.Int("requests", s.requests))
+	return s, nil
+}
+
+// Closes the session and deletes its saved state, so it's never used again.
+func (c *crawler) retireSession(s *session, reason string) {
+	c.log.Debug("retiring session", slog.String("session", s.id), slog.String("reason", reason))
+	s.context.Close()
+	if c.Proxies != nil {
+		c.Proxies.Release(s.id)
+	}
+	if err := c.sessions.store.remove(s.id); err != nil {
+		c.log.Error("failed to delete session", internal.ErrAttr(err), slog.String("session", s.id))
+	}
+}
+
+func (c *crawler) saveSession(s *session) {
+	if c.sessions.store.dir == "" {
+		return
+	}
+	state, err := s.context.StorageState()
+	if err != nil {
+		c.log.Debug("failed to read session state", internal.ErrAttr(err), slog.String("session", s.id))
+		return
+	}
+	err = c.sessions.store.save(savedSession{
+		ID:        s.id,
+		CreatedAt: s.createdAt,
+		Requests:  s.requests,
+		Locations: s.locations,
+		State:     savedState(*state),
+	})
+	if err != nil {
+		c.log.Error("failed to save session", internal.ErrAttr(err), slog.String("session", s.id))
+	}
+}
+
+// Saves and closes the idle sessions, so they can be continued by the next browser.
+// must be called with browserMu held, which guarantees no session is in use
+func (c *crawler) closeSessions() {
+	pool := c.sessions
+	pool.mu.Lock()
+	idle := pool.idle
+	pool.idle = nil
+	pool.mu.Unlock()
+
+	for _, s := range idle {
+		c.saveSession(s)
+		s.context.Close()
+		pool.store.giveBack(s.id)
+	}
+}
+
+// Closes the idle sessions and deletes their saved states, so no cookies of them are reused.
+// must be called with browserMu held, which guarantees no session is in use
+func (c *crawler) retireSessions(reason string) {
+	pool := c.sessions
+	pool.mu.Lock()
+	idle := pool.idle
+	pool.idle = nil
+	pool.mu.Unlock()
+
+	for _, s := range idle {
+		c.retireSession(s, reason)
+	}
+}
+
+// The storage state of a session, as written to the session directory.
+type savedSession struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Requests  int               `json:"requests"`
+	Locations map[string]string `json:"locations,omitempty"`
+	State     savedState        `json:"state"`
+}
+
+type savedState playwright.StorageState
+
+// Converts the state returned by playwright into the state accepted by new contexts.
+func (s savedState) optional() *playwright.OptionalStorageState {
+	cookies := make([]playwright.OptionalCookie, 0, len(s.Cookies))
+	for _, c := range s.Cookies {
+		cookies = append(cookies, playwright.OptionalCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   playwright.String(c.Domain),
+			Path:     playwright.String(c.Path),
+			Expires:  playwright.Float(c.Expires),
+			HttpOnly: playwright.Bool(c.HttpOnly),
+			Secure:   playwright.Bool(c.Secure),
+			SameSite: c.SameSite,
+		})
+	}
+	return &playwright.OptionalStorageState{
+		Cookies: cookies,
+		Origins: s.Origins,
+	}
+}
+
+// Persists session states as one JSON file per session.
+// Saved sessions not used by a live session are available to new sessions.
+type sessionStore struct {
+	dir       string // empty disables saving
+	mu        sync.Mutex
+	available []string
+}
+
+func newSessionStore(dir string) (*sessionStore, error) {
+	store := &sessionStore{dir: dir}
+	if dir == "" {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		store.available = append(store.available, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+	slog.Info(fmt.Sprintf("found %d saved sessions", len(store.available)))
+	return store, nil
+}
+
+// Returns a saved session nobody uses, or nil if there is none.
+func (s *sessionStore) take() *savedSession {
+	for {
+		s.mu.Lock()
+		if len(s.available) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		id := s.available[0]
+		s.available = s.available[1:]
+		s.mu.Unlock()
+
+		saved, err := s.load(id)
+		if err != nil {
+			slog.Warn("discarding unreadable session", internal.ErrAttr(err), slog.String("session", id))
+			s.remove(id)
+			continue
+		}
+		return saved
+	}
+}
+
+// Makes the saved session available to new sessions again.
+func (s *sessionStore) giveBack(id string) {
+	if s.dir == "" {
+		return
+	}
+	if _, err := os.Stat(s.path(id)); err != nil {
+		return // never saved
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.available, id) {
+		s.available = append(s.available, id)
+	}
+}
+
+func (s *sessionStore) load(id string) (*savedSession, error) {
+	b, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var saved savedSession
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	saved.ID = id
+	return &saved, nil
+}
+
+func (s *sessionStore) save(saved savedSession) error {
+	if s.dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, so a crash never leaves a truncated session
+	tmp := s.path(saved.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(saved.ID))
+}
+
+func (s *sessionStore) remove(id string) error {
+	if s.dir == "" {
+		return nil
+	}
+	err := os.Remove(s.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *sessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,91 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestSessionExpired(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  session
+		opts     SessionOptions
+		expected bool
+	}{
+		{"no limits", session{createdAt: time.Now().Add(-time.Hour), requests: 1000}, SessionOptions{}, false},
+		{"below limits", session{createdAt: time.Now(), requests: 4}, SessionOptions{MaxAge: time.Hour, MaxRequests: 5}, false},
+		{"max requests", session{createdAt: time.Now(), requests: 5}, SessionOptions{MaxAge: time.Hour, MaxRequests: 5}, true},
+		{"max age", session{createdAt: time.Now().Add(-2 * time.Hour), requests: 1}, SessionOptions{MaxAge: time.Hour, MaxRequests: 5}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.session.expired(test.opts); got != test.expected {
+				t.Errorf("got %v, want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := store.take(); saved != nil {
+		t.Fatalf("empty store returned session %s", saved.ID)
+	}
+
+	saved := savedSession{
+		ID:        "a",
+		CreatedAt: time.Now().Truncate(time.Second),
+		Requests:  3,
+		State: savedState{
+			Cookies: []playwright.Cookie{{Name: "session-id", Value: "123", Domain: ".amazon.com", Path: "/"}},
+		},
+	}
+	if err := store.save(saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted crawler finds the saved sessions
+	store, err = newSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := store.take()
+	if got == nil || got.ID != "a" || got.Requests != 3 || !got.CreatedAt.Equal(saved.CreatedAt) {
+		t.Fatalf("got %+v, want %+v", got, saved)
+	}
+	if cookies := got.State.optional().Cookies; len(cookies) != 1 || *cookies[0].Domain != ".amazon.com" {
+		t.Errorf("unexpected cookies %+v", cookies)
+	}
+
+	// sessions in use aren't handed out twice
+	if again := store.take(); again != nil {
+		t.Errorf("session %s taken twice", again.ID)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.json")); !os.IsNotExist(err) {
+		t.Error("unreadable session wasn't deleted")
+	}
+	store.giveBack("a")
+	if again := store.take(); again == nil || again.ID != "a" {
+		t.Error("session given back isn't available")
+	}
+
+	if err := store.remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	store.giveBack("a")
+	if again := store.take(); again != nil {
+		t.Error("removed session is available")
+	}
+}
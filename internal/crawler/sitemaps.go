@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/polite"
+	"github.com/jonashiltl/amazon-crawler/internal/sitemap"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// urls of sitemaps are queued in batches of this size
+const sitemapBatchSize = 500
+
+// Reads the sitemaps in the background and queues their relevant urls, as a source next to the seed urls.
+func (c *crawler) startSitemapDiscovery() {
+	if len(c.Sitemaps) == 0 && !c.SitemapsFromRobots {
+		return
+	}
+	go func() {
+		sitemaps := slices.Clone(c.Sitemaps)
+		if c.SitemapsFromRobots {
+			robots := polite.NewRobotsChecker(polite.Options{Proxy: robotsProxy(c.Proxies)})
+			for _, marketplace := range c.Marketplaces {
+				found, err := robots.Sitemaps("https://" + marketplace)
+				if err != nil {
+					c.log.Error("failed to find sitemaps", internal.ErrAttr(err), slog.String("marketplace", marketplace))
+				}
+				sitemaps = append(sitemaps, found...)
+			}
+		}
+
+		fetcher := sitemap.NewFetcher(sitemap.Options{Proxy: robotsProxy(c.Proxies)})
+		for _, s := range sitemaps {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.ingestSitemap(fetcher, s)
+		}
+	}()
+}
+
+func (c *crawler) ingestSitemap(fetcher *sitemap.Fetcher, sitemapURL string) {
+	var batch []storage.Link
+	read, queued := 0, 0
+	flush := func() error {
+		added, err := c.Storage.AddURLs(c.ctx, c.withoutTraps(c.withinBudget(batch)))
+		queued += len(added)
+		batch = batch[:0]
+		return err
+	}
+
+	err := fetcher.Walk(c.ctx, sitemapURL, func(e sitemap.Entry) error {
+		read++
+		url, ok := sitemapLink(e.Loc)
+		if !ok {
+			return nil
+		}
+		link := storage.Link{
+			URL:     url,
+			Seed:    sitemapURL,
+			Context: storage.LinkSitemap,
+			LastMod: e.LastMod,
+		}
+		link.Priority = c.LinkPriority(link)
+		batch = append(batch, link)
+		if len(batch) >= sitemapBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	err = errors.Join(err, flush())
+	if err != nil && c.ctx.Err() == nil {
+		c.log.Error("failed to ingest sitemap", internal.ErrAttr(err), slog.String("url", sitemapURL))
+	}
+	c.log.Info(fmt.Sprintf("queued %d of %d sitemap urls", queued, read), slog.String("sitemap", sitemapURL))
+}
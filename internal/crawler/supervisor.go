@@ -0,0 +1,260 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	restartBackoff    = 2 * time.Second
+	restartBackoffMax = time.Minute
+	memoryCheckPeriod = 30 * time.Second
+)
+
+// Replaces the browser of the old identity with a new browser and identity.
+// The new browser is launched while the old one keeps serving jobs, browserMu is only
+// held to swap them, which waits for the jobs using the old browser to finish.
+// Failed starts are retried with backoff. Gives up and stops the crawler once
+// more than MaxRestarts restarts happened within the RestartWindow.
+// Planned recycles, unlike crashes, only count toward MaxRestarts if their launch fails.
+func (c *crawler) restartBrowser(old *identity, reason string, planned bool) {
+	if c.workCtx.Err() != nil {
+		return // shutting down, the browser isn't needed anymore
+	}
+	if !c.restarting.CompareAndSwap(false, true) {
+		return // another goroutine already restarts
+	}
+	defer c.restarting.Store(false)
+
+	c.browserMu.RLock()
+	current := c.identity
+	c.browserMu.RUnlock()
+	if current != old {
+		return // already restarted or rotated
+	}
+
+	c.log.Info("restarting browser", slog.String("reason", reason), slog.String("identity", old.id))
+
+	backoff := restartBackoff
+	counted := !planned
+	var next *runningBrowser
+	var id *identity
+	for {
+		if counted && !c.allowRestart() {
+			c.log.Error(fmt.Sprintf("browser restarted more than %d times within %s, giving up", c.MaxRestarts, c.RestartWindow))
+			c.Cancel()
+			return
+		}
+
+		var err error
+		next, id, err = c.startBrowser()
+		if err == nil {
+			break
+		}
+		c.log.Error("failed to restart browser", internal.ErrAttr(err))
+		counted = true
+
+		select {
+		case <-c.workCtx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, restartBackoffMax)
+	}
+
+	c.browserMu.Lock()
+	// the identity may have been rotated during the launch, whichever is current is retired
+	c.retireIdentity(c.identity)
+	c.closeSessions()
+	previous := c.browser
+	c.browser = next
+	c.identity = id
+	c.browserMu.Unlock()
+
+	previous.close()
+}
+
+// Launches a browser with a new identity and makes it the browser of the crawler.
+// must be called with browserMu held
+func (c *crawler) launchBrowser() error {
+	b, id, err := c.startBrowser()
+	if err != nil {
+		return err
+	}
+	c.identity = id
+	c.browser = b
+	return nil
+}
+
+// Launches a browser with a new identity, without using it yet.
+func (c *crawler) startBrowser() (*runningBrowser, *identity, error) {
+	id := c.newIdentity()
+	b, err := c.Launcher.Launch(c.pw, id.proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	id.userAgent = b.UserAgent
+
+	running := &runningBrowser{Browser: b}
+	// also fires when the browser process crashed
+	b.OnDisconnected(func(playwright.Browser) {
+		if running.closing.Load() {
+			return
+		}
+		running.crashed.Store(true)
+		c.log.Error("browser disconnected unexpectedly", slog.String("identity", id.id))
+		// not on playwright's event goroutine, the restart waits for jobs that need it
+		go func() {
+			// the identity may have been rotated since the launch
+			c.browserMu.RLock()
+			current := c.identity
+			same := c.browser == running
+			c.browserMu.RUnlock()
+			if same {
+				c.restartBrowser(current, "browser crashed", false)
+			}
+		}()
+	})
+
+	return running, id, nil
+}
+
+// A launched browser, as long as the crawler uses it.
+type runningBrowser struct {
+	*Browser
+	closing atomic.Bool // set before an intentional close, so the disconnect isn't treated as a crash
+	crashed atomic.Bool
+}
+
+func (b *runningBrowser) close() {
+	b.closing.Store(true)
+	b.Close()
+	if b.Stop != nil {
+		b.Stop()
+	}
+}
+
+// Records a restart and reports whether it stays within the allowed restarts per window.
+func (c *crawler) allowRestart() bool {
+	now := time.Now()
+	recent := c.restarts[:0]
+	for _, t := range c.restarts {
+		if now.Sub(t) < c.RestartWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.restarts = append(recent, now)
+	return len(c.restarts) <= c.MaxRestarts
+}
+
+// Puts the url of a job interrupted by a browser crash back into the queue.
+func (c *crawler) requeue(job storage.QueuedURL) {
+	c.log.Info("requeueing url after browser crash", slog.String("url", job.URL))
+	if err := c.Storage.ReleaseURL(c.workCtx, job.URL); err != nil {
+		c.log.Error(err.Error())
+	}
+}
+
+// Restarts the browser once it served MaxPagesPerBrowser pages,
+// so a fingerprint isn't reused for too long.
+func (c *crawler) checkPageLimit(id *identity) {
+	if c.MaxPagesPerBrowser <= 0 || id.requests.Load() < int64(c.MaxPagesPerBrowser) {
+		return
+	}
+	go c.restartBrowser(id, fmt.Sprintf("served %d pages", c.MaxPagesPerBrowser), true)
+}
+
+// Periodically restarts the browser if its memory usage grew above MaxBrowserMemoryMB.
+func (c *crawler) startMemoryWatchdog() {
+	if c.MaxBrowserMemoryMB <= 0 {
+		return
+	}
+	if c.browser.Pid == 0 {
+		c.log.Warn("the memory of the browser can't be checked, its process isn't started by the crawler")
+		return
+	}
+
+	ticker := time.NewTicker(memoryCheckPeriod)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.workCtx.Done():
+				return
+			case <-ticker.C:
+				c.browserMu.RLock()
+				id := c.identity
+				pid := c.browser.Pid
+				c.browserMu.RUnlock()
+
+				rss, err := processGroupRSS(pid)
+				if err != nil {
+					c.log.Debug("failed to read browser memory", internal.ErrAttr(err))
+					continue
+				}
+				mb := rss / (1024 * 1024)
+				c.log.Debug(fmt.Sprintf("browser uses %dMB memory", mb))
+				if mb > uint64(c.MaxBrowserMemoryMB) {
+					c.restartBrowser(id, fmt.Sprintf("memory usage of %dMB", mb), true)
+				}
+			}
+		}
+	}()
+}
+
+// Sums the resident memory of all processes in the process group, which includes
+// xvfb-run, Xvfb, the python launcher and the browser processes.
+// Only supported on Linux.
+func processGroupRSS(pgid int) (uint64, error) {
+	statFiles, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, err
+	}
+	if len(statFiles) == 0 {
+		return 0, fmt.Errorf("procfs not available")
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	var total uint64
+	for _, statFile := range statFiles {
+		stat, err := os.ReadFile(statFile)
+		if err != nil {
+			continue // the process exited
+		}
+		// the command name in parentheses may contain spaces, the fields after it don't
+		end := strings.LastIndexByte(string(stat), ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[end+1:]))
+		// fields: state, ppid, pgrp, ...
+		if len(fields) < 3 || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+
+		statm, err := os.ReadFile(filepath.Join(filepath.Dir(statFile), "statm"))
+		if err != nil {
+			continue
+		}
+		memFields := strings.Fields(string(statm))
+		if len(memFields) < 2 {
+			continue
+		}
+		resident, err := strconv.ParseUint(memFields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += resident * pageSize
+	}
+	return total, nil
+}
@@ -0,0 +1,254 @@
+package trap
+
+import (
+	"container/list"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+type Options struct {
+	MaxURLs      int           // urls a template may add to the queue, 0 disables the cap
+	MinPages     int           // consecutive pages of a template without new products before it's suppressed, 0 disables
+	MaxTemplates int           // templates kept, the least recently seen are forgotten first, 0 is unlimited
+	IdleTimeout  time.Duration // templates not seen for this long are forgotten, resetting their counts, 0 keeps them
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxURLs:      50000,
+		MinPages:     50,
+		MaxTemplates: 100000,
+		IdleTimeout:  6 * time.Hour,
+	}
+}
+
+// Groups urls by their pattern, e.g. "/s?i=toys&k=lego&page", and stops following patterns
+// that add too many urls or whose pages stopped leading to new products.
+// Product urls are never suppressed, they are the goal of the crawl.
+// Only patterns of observed urls are tracked, the least recently seen first to be forgotten.
+type Detector struct {
+	opts      Options
+	mu        sync.Mutex
+	templates map[string]*list.Element // of *template, in lru
+	lru       *list.List               // most recently seen first
+	now       func() time.Time
+}
+
+type template struct {
+	key            string
+	seenAt         time.Time
+	added          int // urls of the template added to the queue
+	pages          int // pages of the template that were crawled
+	pagesNoProduct int // consecutive pages without new products
+	newProducts    int // new products found on pages of the template
+	suppressed     string
+	suppressedAt   time.Time
+}
+
+func New(opts Options) *Detector {
+	return &Detector{
+		opts:      opts,
+		templates: make(map[string]*list.Element),
+		lru:       list.New(),
+		now:       time.Now,
+	}
+}
+
+// Query parameters whose values identify a search or category. Their values are part of the template,
+// so every search is capped on its own instead of all searches of the crawl together.
+var identifyingParams = map[string]bool{
+	"k":              true,
+	"keywords":       true,
+	"field-keywords": true,
+	"i":              true,
+	"search-alias":   true,
+	"node":           true,
+	"bbn":            true,
+}
+
+// Returns the pattern of the url: the path with numbers replaced by {n} and the sorted names of its query parameters,
+// with the values of the identifying parameters.
+func Template(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		if s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) == -1 {
+			segments[i] = "{n}"
+		}
+	}
+	template := strings.Join(segments, "/")
+
+	q := u.Query()
+	params := make([]string, 0, len(q))
+	for name, values := range q {
+		if identifyingParams[name] {
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(strings.Join(values, ",")))
+		} else {
+			params = append(params, url.QueryEscape(name))
+		}
+	}
+	if len(params) == 0 {
+		return template
+	}
+	slices.Sort(params)
+	return template + "?" + strings.Join(params, "&")
+}
+
+func isProduct(rawURL string) bool {
+	_, err := internal.AsinFromURL(rawURL)
+	return err == nil
+}
+
+// Returns the template of the url if it's tracked, marking it as seen.
+// must be called with mu held
+func (d *Detector) lookup(rawURL string) *template {
+	d.forgetIdle()
+	e, ok := d.templates[Template(rawURL)]
+	if !ok {
+		return nil
+	}
+	t := e.Value.(*template)
+	t.seenAt = d.now()
+	d.lru.MoveToFront(e)
+	return t
+}
+
+// Returns the template of the url, tracking it if it's new.
+// must be called with mu held
+func (d *Detector) template(rawURL string) *template {
+	if t := d.lookup(rawURL); t != nil {
+		return t
+	}
+	t := &template{key: Template(rawURL), seenAt: d.now()}
+	d.templates[t.key] = d.lru.PushFront(t)
+	if d.opts.MaxTemplates > 0 && d.lru.Len() > d.opts.MaxTemplates {
+		d.forget(d.lru.Back())
+	}
+	return t
+}
+
+// Forgets the templates not seen within the idle timeout.
+// must be called with mu held
+func (d *Detector) forgetIdle() {
+	if d.opts.IdleTimeout <= 0 {
+		return
+	}
+	for e := d.lru.Back(); e != nil && d.now().Sub(e.Value.(*template).seenAt) >= d.opts.IdleTimeout; e = d.lru.Back() {
+		d.forget(e)
+	}
+}
+
+// must be called with mu held
+func (d *Detector) forget(e *list.Element) {
+	d.lru.Remove(e)
+	delete(d.templates, e.Value.(*template).key)
+}
+
+// Reports whether the url may be queued. Doesn't track the template of the url.
+func (d *Detector) Allow(rawURL string) bool {
+	if isProduct(rawURL) {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.lookup(rawURL)
+	return t == nil || t.suppressed == ""
+}
+
+// Records a crawled page and the urls it added to the queue, which were unknown before.
+// Suppresses the templates that reached their cap or stopped yielding new products and returns them.
+func (d *Detector) Observe(pageURL string, added []string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var suppressed []string
+	newProducts := 0
+	for _, u := range added {
+		if isProduct(u) {
+			newProducts++
+			continue
+		}
+		t := d.template(u)
+		t.added++
+		if d.opts.MaxURLs > 0 && t.added >= d.opts.MaxURLs && t.suppress("too many urls", d.now()) {
+			suppressed = append(suppressed, Template(u))
+		}
+	}
+
+	if isProduct(pageURL) {
+		return suppressed
+	}
+	t := d.template(pageURL)
+	t.pages++
+	t.newProducts += newProducts
+	if newProducts > 0 {
+		t.pagesNoProduct = 0
+		return suppressed
+	}
+	t.pagesNoProduct++
+	if d.opts.MinPages > 0 && t.pagesNoProduct >= d.opts.MinPages && t.suppress("no new products", d.now()) {
+		suppressed = append(suppressed, Template(pageURL))
+	}
+	return suppressed
+}
+
+// Returns false if the template was already suppressed.
+func (t *template) suppress(reason string, now time.Time) bool {
+	if t.suppressed != "" {
+		return false
+	}
+	t.suppressed = reason
+	t.suppressedAt = now
+	return true
+}
+
+type Suppressed struct {
+	Template     string    `json:"template"`
+	Reason       string    `json:"reason"`
+	SuppressedAt time.Time `json:"suppressedAt"`
+	Added        int       `json:"added"`
+	Pages        int       `json:"pages"`
+	NewProducts  int       `json:"newProducts"`
+}
+
+type Report struct {
+	Templates  int          `json:"templates"`
+	Suppressed []Suppressed `json:"suppressed"`
+}
+
+// Returns the suppressed templates, the most recently suppressed first.
+func (d *Detector) Report() Report {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.forgetIdle()
+	report := Report{Templates: len(d.templates), Suppressed: []Suppressed{}}
+	for e := d.lru.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*template)
+		if t.suppressed == "" {
+			continue
+		}
+		report.Suppressed = append(report.Suppressed, Suppressed{
+			Template:     t.key,
+			Reason:       t.suppressed,
+			SuppressedAt: t.suppressedAt,
+			Added:        t.added,
+			Pages:        t.pages,
+			NewProducts:  t.newProducts,
+		})
+	}
+	slices.SortFunc(report.Suppressed, func(a, b Suppressed) int {
+		return b.SuppressedAt.Compare(a.SuppressedAt)
+	})
+	return report
+}
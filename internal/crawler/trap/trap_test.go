@@ -0,0 +1,135 @@
+package trap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.amazon.com/s?k=lego&page=2", "/s?k=lego&page"},
+		{"https://www.amazon.com/s?page=3&k=lego", "/s?k=lego&page"},
+		{"https://www.amazon.com/s?page=3&k=duplo+bricks", "/s?k=duplo+bricks&page"},
+		{"https://www.amazon.com/s?bbn=1&i=toys&k=lego&rnid=2", "/s?bbn=1&i=toys&k=lego&rnid"},
+		{"https://www.amazon.com/s?k=lego&rh=p_89%3ALEGO", "/s?k=lego&rh"},
+		{"https://www.amazon.com/b?node=165793011", "/b?node=165793011"},
+		{"https://www.amazon.com/gp/bestsellers/toys-and-games/166092011", "/gp/bestsellers/toys-and-games/{n}"},
+		{"https://www.amazon.com/b", "/b"},
+	}
+
+	for _, test := range tests {
+		if result := Template(test.url); result != test.expected {
+			t.Errorf("Template(%q) = %q; want %q", test.url, result, test.expected)
+		}
+	}
+}
+
+func TestMaxURLs(t *testing.T) {
+	d := New(Options{MaxURLs: 3})
+	d.Observe("https://www.amazon.com/s?k=lego", []string{
+		"https://www.amazon.com/s?k=lego&rnid=1",
+		"https://www.amazon.com/s?k=lego&rnid=2",
+	})
+	if !d.Allow("https://www.amazon.com/s?k=lego&rnid=3") {
+		t.Fatal("template suppressed below its cap")
+	}
+
+	suppressed := d.Observe("https://www.amazon.com/s?k=lego", []string{"https://www.amazon.com/s?k=lego&rnid=3"})
+	if len(suppressed) != 1 || suppressed[0] != "/s?k=lego&rnid" {
+		t.Errorf("Observe() = %v; want the suppressed template", suppressed)
+	}
+	if d.Allow("https://www.amazon.com/s?k=lego&rnid=4") {
+		t.Error("template not suppressed after reaching its cap")
+	}
+	if !d.Allow("https://www.amazon.com/s?k=lego") {
+		t.Error("other template suppressed")
+	}
+	if !d.Allow("https://www.amazon.com/s?k=duplo&rnid=4") {
+		t.Error("template of another search suppressed")
+	}
+}
+
+func TestNoNewProducts(t *testing.T) {
+	d := New(Options{MinPages: 3})
+	search := func(page int) string {
+		return fmt.Sprintf("https://www.amazon.com/s?i=toys&k=lego&page=%d", page)
+	}
+
+	d.Observe(search(1), nil)
+	d.Observe(search(2), nil)
+	// a new product resets the count
+	d.Observe(search(3), []string{"https://www.amazon.com/dp/B00NHQFA1I"})
+	d.Observe(search(4), nil)
+	d.Observe(search(5), nil)
+	if !d.Allow(search(6)) {
+		t.Fatal("template suppressed while it yields products")
+	}
+	d.Observe("https://www.amazon.com/s?i=toys&k=duplo&page=2", nil)
+
+	d.Observe(search(6), nil)
+	if d.Allow(search(7)) {
+		t.Error("template not suppressed after pages without new products")
+	}
+	if !d.Allow("https://www.amazon.com/s?i=toys&k=duplo&page=3") {
+		t.Error("pages of another search count against the template")
+	}
+
+	// products are never suppressed, product pages rarely link to new products
+	for range 5 {
+		d.Observe("https://www.amazon.com/dp/B00NHQFA1I", nil)
+	}
+	if !d.Allow("https://www.amazon.com/dp/B00NHQFA1I") {
+		t.Error("product suppressed")
+	}
+
+	report := d.Report()
+	if len(report.Suppressed) != 1 {
+		t.Fatalf("expected one suppressed template, got %+v", report.Suppressed)
+	}
+	s := report.Suppressed[0]
+	if s.Template != "/s?i=toys&k=lego&page" || s.Reason != "no new products" || s.Pages != 6 || s.NewProducts != 1 {
+		t.Errorf("unexpected report %+v", s)
+	}
+}
+
+func TestForgetTemplates(t *testing.T) {
+	now := time.Now()
+	d := New(Options{MinPages: 1, MaxTemplates: 2, IdleTimeout: time.Hour})
+	d.now = func() time.Time { return now }
+
+	for i := range 10 {
+		d.Allow(fmt.Sprintf("https://www.amazon.com/s?k=lego%d", i))
+	}
+	if n := d.Report().Templates; n != 0 {
+		t.Fatalf("Allow() tracked %d templates", n)
+	}
+
+	d.Observe("https://www.amazon.com/s?k=lego", nil)
+	d.Observe("https://www.amazon.com/s?k=duplo", nil)
+	if d.Allow("https://www.amazon.com/s?k=lego") {
+		t.Fatal("template not suppressed")
+	}
+	// the least recently seen template is forgotten first
+	d.Observe("https://www.amazon.com/s?k=technic", []string{"https://www.amazon.com/dp/B00NHQFA1I"})
+	if n := d.Report().Templates; n != 2 {
+		t.Errorf("expected 2 templates, got %d", n)
+	}
+	if !d.Allow("https://www.amazon.com/s?k=duplo") {
+		t.Error("least recently seen template still suppressed")
+	}
+	if d.Allow("https://www.amazon.com/s?k=lego") {
+		t.Error("recently seen template forgotten")
+	}
+
+	now = now.Add(time.Hour)
+	if !d.Allow("https://www.amazon.com/s?k=lego") {
+		t.Error("idle template still suppressed")
+	}
+	if n := d.Report().Templates; n != 0 {
+		t.Errorf("expected idle templates to be forgotten, got %d", n)
+	}
+}
@@ -0,0 +1,33 @@
+package crawler
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
+
+// Drops the links whose url pattern is suppressed as a crawler trap.
+func (c *crawler) withoutTraps(links []storage.Link) []storage.Link {
+	if c.Traps == nil {
+		return links
+	}
+	return slices.DeleteFunc(links, func(l storage.Link) bool {
+		return !c.Traps.Allow(l.URL)
+	})
+}
+
+// Reports the urls the page added to the queue to the trap detector.
+func (c *crawler) observeTraps(page string, added []storage.Link) {
+	if c.Traps == nil {
+		return
+	}
+	urls := make([]string, 0, len(added))
+	for _, l := range added {
+		urls = append(urls, l.URL)
+	}
+
+	for _, template := range c.Traps.Observe(page, urls) {
+		c.log.Warn("suppressing crawler trap", slog.String("template", template), slog.String("page", page))
+	}
+}
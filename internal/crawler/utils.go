@@ -1,108 +1,129 @@
 package crawler
 
 import (
-	"fmt"
 	"net/url"
-	"regexp"
 	"strings"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/canonical"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/classify"
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
 )
 
+// Search and category pages lead to further products.
 func isRelevantURL(url string) bool {
 	// lanaguage specific urls like /-/es/
-	re := regexp.MustCompile(`/-/[a-z]{2}/`)
-	if re.MatchString(url) {
+	if classify.IsTranslated(url) {
 		return false
 	}
 
-	return isSearchURL(url) || isCategoryURL(url)
+	switch classify.FromURL(url) {
+	case classify.Search:
+		return true
+	case classify.Category:
+		// not interested in amazon video
+		return !strings.Contains(url, "/Amazon-Video/")
+	default:
+		return false
+	}
 }
 
-func isSearchURL(url string) bool {
-	contains := []string{"/s?", "/s/"}
-	for _, c := range contains {
-		if strings.Contains(url, c) {
-			return true
-		}
+const AMAZON_BASE_URL = "https://" + canonical.Host
+
+// Returns the scheme and host of the page url, so relative links of pages
+// served by another host, e.g. a local test server, stay on that host.
+// Falls back to AMAZON_BASE_URL if the url has no host.
+func baseURLOf(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return AMAZON_BASE_URL
 	}
-	return false
+	return u.Scheme + "://" + u.Host
 }
 
-func isCategoryURL(url string) bool {
-	// not interested in amazon video
-	if strings.Contains(url, "/Amazon-Video/") {
-		return false
+// Returns the host of the url, e.g. the marketplace of a canonical Amazon url.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
 	}
+	return u.Host
+}
 
-	contains := []string{"/b?", "/b/"}
-	for _, c := range contains {
-		if strings.Contains(url, c) {
-			return true
+// Resolves the link against the base url and returns its canonical form.
+// Returns an empty string for links that can't be parsed.
+func withBaseURL(baseURL string, href string) string {
+	if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") {
+		if !strings.HasPrefix(href, "/") {
+			href = "/" + href
 		}
+		href = baseURL + href
 	}
-	return false
-}
 
-const AMAZON_BASE_URL = "https://amazon.com"
-
-func createProductURL(asin string) string {
-	return fmt.Sprintf("%s/dp/%s", AMAZON_BASE_URL, asin)
-}
-
-var ALLOWED_SEARCH_PARAMS = map[string]bool{
-	"rnid":     true,
-	"node":     true,
-	"bbn":      true,
-	"keywords": true,
-	"k":        true,
-	"c":        true,
-	"i":        true,
-	"page":     true,
-	// "rh":             true, // used for filtering items based on their attributes
-	"sprefix":        true,
-	"search-alias":   true,
-	"field-author":   true,
-	"field-keywords": true,
-	"text":           true,
-	// "language": true
+	canonicalURL, err := canonical.URL(href)
+	if err != nil {
+		return ""
+	}
+	return canonicalURL
 }
 
-func withBaseURL(href string) string {
-	if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
-		return filterQueryParams(href)
+// Creates the link to url found on the page of the job, one level deeper than the job.
+// Jobs queued before lineage was tracked have no seed, they become the seed of their links.
+func childLink(job storage.QueuedURL, url string, context storage.LinkContext) storage.Link {
+	seed := job.Seed
+	if seed == "" {
+		seed = job.URL
 	}
-
-	if !strings.HasPrefix(href, "/") {
-		href = "/" + href
+	return storage.Link{
+		URL:     url,
+		Parent:  job.URL,
+		Seed:    seed,
+		Depth:   job.Depth + 1,
+		Context: context,
+		Tag:     job.Tag,
 	}
-
-	full := AMAZON_BASE_URL + href
-	return filterQueryParams(full)
 }
 
-func filterQueryParams(rawURL string) string {
-	parsedURL, err := url.Parse(rawURL)
-	if err != nil {
+// The context of links found outside of a specific section of the page, by the type of the linked page.
+func linkContextOf(url string) storage.LinkContext {
+	switch classify.FromURL(url) {
+	case classify.Search:
+		return storage.LinkSearch
+	case classify.Category:
+		return storage.LinkCategory
+	case classify.Product:
+		return storage.LinkProduct
+	default:
 		return ""
 	}
+}
 
-	q := parsedURL.Query()
-	filtered := url.Values{}
-	for key, val := range q {
-		if ALLOWED_SEARCH_PARAMS[key] {
-			filtered[key] = val
-		}
-	}
-	parsedURL.RawQuery = filtered.Encode()
+// Base priority of links by where they were found. Seeds start the crawl, products are its goal,
+// the next page of a listing keeps its products coming.
+var linkContextPriority = map[storage.LinkContext]int{
+	storage.LinkSeed:           120,
+	storage.LinkProduct:        100,
+	storage.LinkBoughtTogether: 90,
+	storage.LinkPagination:     80,
+	storage.LinkSitemap:        70,
+	storage.LinkFacet:          60,
+	storage.LinkSearch:         50,
+	storage.LinkCategory:       40,
+}
 
-	// clean "ref=..." path
-	segments := strings.Split(parsedURL.Path, "/")
-	var cleanSegments []string
-	for _, s := range segments {
-		if s != "" && !strings.HasPrefix(s, "ref=") && !strings.HasPrefix(s, "ref-") {
-			cleanSegments = append(cleanSegments, s)
-		}
-	}
-	parsedURL.Path = "/" + strings.Join(cleanSegments, "/")
+// Prefers products and pagination, and shallow links over deep ones of the same context.
+func DefaultLinkPriority(link storage.Link) int {
+	return linkContextPriority[link.Context] - 5*link.Depth
+}
 
-	return parsedURL.String()
+// Applies the relevance and canonicalization rules of links found on pages to an url of a sitemap.
+func sitemapLink(loc string) (string, bool) {
+	if _, err := internal.AsinFromURL(loc); err != nil && !isRelevantURL(loc) {
+		return "", false
+	}
+	url, err := canonical.URL(canonical.WithoutRefinements(loc))
+	if err != nil {
+		return "", false
+	}
+	return url, true
 }
@@ -1,6 +1,11 @@
 package crawler
 
-import "testing"
+import (
+	"slices"
+	"testing"
+
+	"github.com/jonashiltl/amazon-crawler/internal/storage"
+)
 
 func TestIsRelevantURL(t *testing.T) {
 	tests := []struct {
@@ -36,60 +41,136 @@ func TestIsRelevantURL(t *testing.T) {
 	}
 }
 
-func TestFilterQueryParams(t *testing.T) {
+func TestWithBaseURL(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    string
+		pageURL  string
+		href     string
 		expected string
+	}{
+		{"https://www.amazon.com/s?k=lego", "/b?node=123", "https://www.amazon.com/b?node=123"},
+		{"https://www.amazon.com/s?k=lego", "b?node=123", "https://www.amazon.com/b?node=123"},
+		{"http://localhost:8080/s?k=lego", "/s?k=duplo&page=2", "http://localhost:8080/s?k=duplo&page=2"},
+		{"http://localhost:8080/s?k=lego", "https://amazon.com/b?node=1", "https://www.amazon.com/b?node=1"},
+		{"about:blank", "/b?node=123", "https://www.amazon.com/b?node=123"},
+		{"https://www.amazon.com/s?k=lego", "/LEGO-Classic/dp/B00NHQFA1I/ref=sr_1_1?psc=1", "https://www.amazon.com/dp/B00NHQFA1I"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pageURL+" "+test.href, func(t *testing.T) {
+			got := withBaseURL(baseURLOf(test.pageURL), test.href)
+			if got != test.expected {
+				t.Errorf("got %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestChildLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      storage.QueuedURL
+		expected storage.Link
 	}{
 		{
-			name:     "Removes /ref=... in path and keeps allowed param",
-			input:    "https://amazon.com/b/ref=SHCC/?node=23528055011",
-			expected: "https://amazon.com/b?node=23528055011",
-		},
-		{
-			name:     "Keeps allowed query param, strips ref from path",
-			input:    "https://amazon.com/some/ref=abc123/path?node=123&bad=1",
-			expected: "https://amazon.com/some/path?node=123",
-		},
-		{
-			name:     "Removes /ref=... with allowed 'k' param",
-			input:    "https://amazon.com/search/ref=something?k=headphones&foo=bar",
-			expected: "https://amazon.com/search?k=headphones",
-		},
-		{
-			name:     "Removes trailing /ref=... with no query params",
-			input:    "https://amazon.com/b/ref=SHCC/",
-			expected: "https://amazon.com/b",
-		},
-		{
-			name:     "Keeps multiple allowed query params",
-			input:    "https://amazon.com/s?node=123&k=ipad&junk=1",
-			expected: "https://amazon.com/s?k=ipad&node=123",
-		},
-		{
-			name:     "No path ref, no query params",
-			input:    "https://amazon.com/dp/B08N5WRWNW",
-			expected: "https://amazon.com/dp/B08N5WRWNW",
+			name: "seed",
+			job:  storage.QueuedURL{URL: "https://www.amazon.com/s?k=lego", Seed: "https://www.amazon.com/s?k=lego"},
+			expected: storage.Link{
+				URL:     "https://www.amazon.com/dp/B00NHQFA1I",
+				Parent:  "https://www.amazon.com/s?k=lego",
+				Seed:    "https://www.amazon.com/s?k=lego",
+				Depth:   1,
+				Context: storage.LinkProduct,
+			},
 		},
 		{
-			name:     "Removes /ref-",
-			input:    "https://amazon.com/ref-GC_AGCLP_Congrats_SUB/s/?bbn=2973109011&i=gift-cards",
-			expected: "https://amazon.com/s?bbn=2973109011&i=gift-cards",
+			name: "descendant",
+			job:  storage.QueuedURL{URL: "https://www.amazon.com/s?k=lego&page=2", Seed: "https://www.amazon.com/s?k=lego", Depth: 3, Tag: "lego"},
+			expected: storage.Link{
+				URL:     "https://www.amazon.com/dp/B00NHQFA1I",
+				Parent:  "https://www.amazon.com/s?k=lego&page=2",
+				Seed:    "https://www.amazon.com/s?k=lego",
+				Depth:   4,
+				Context: storage.LinkProduct,
+				Tag:     "lego",
+			},
 		},
 		{
-			name:     "Empty input string",
-			input:    "",
-			expected: "/",
+			name: "queued before lineage was tracked",
+			job:  storage.QueuedURL{URL: "https://www.amazon.com/b?node=1", Depth: 0},
+			expected: storage.Link{
+				URL:     "https://www.amazon.com/dp/B00NHQFA1I",
+				Parent:  "https://www.amazon.com/b?node=1",
+				Seed:    "https://www.amazon.com/b?node=1",
+				Depth:   1,
+				Context: storage.LinkProduct,
+			},
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := filterQueryParams(tt.input)
-			if result != tt.expected {
-				t.Errorf("got %q, want %q", result, tt.expected)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := childLink(test.job, "https://www.amazon.com/dp/B00NHQFA1I", storage.LinkProduct)
+			if result != test.expected {
+				t.Errorf("childLink() = %+v; want %+v", result, test.expected)
 			}
 		})
 	}
 }
+
+func TestWithinDepth(t *testing.T) {
+	links := []storage.Link{{URL: "a", Depth: 1}, {URL: "b", Depth: 2}, {URL: "c", Depth: 3}}
+
+	c := &crawler{Options: Options{MaxDepth: 2}}
+	result := c.withinDepth(slices.Clone(links))
+	if len(result) != 2 || result[0].URL != "a" || result[1].URL != "b" {
+		t.Errorf("withinDepth() = %+v; want links a and b", result)
+	}
+
+	c = &crawler{}
+	if result := c.withinDepth(slices.Clone(links)); len(result) != 3 {
+		t.Errorf("withinDepth() without max depth = %+v; want all links", result)
+	}
+}
+
+func TestDefaultLinkPriority(t *testing.T) {
+	nextPage := storage.Link{Context: storage.LinkPagination, Depth: 2}
+	deepCategory := storage.Link{Context: storage.LinkCategory, Depth: 5}
+	shallowCategory := storage.Link{Context: storage.LinkCategory, Depth: 1}
+	product := storage.Link{Context: storage.LinkProduct, Depth: 3}
+	seed := storage.Link{Context: storage.LinkSeed}
+	shallowProduct := storage.Link{Context: storage.LinkProduct, Depth: 1}
+
+	if DefaultLinkPriority(nextPage) <= DefaultLinkPriority(deepCategory) {
+		t.Errorf("next page %d should be above deep category %d", DefaultLinkPriority(nextPage), DefaultLinkPriority(deepCategory))
+	}
+	if DefaultLinkPriority(shallowCategory) <= DefaultLinkPriority(deepCategory) {
+		t.Errorf("shallow category %d should be above deep category %d", DefaultLinkPriority(shallowCategory), DefaultLinkPriority(deepCategory))
+	}
+	if DefaultLinkPriority(product) <= DefaultLinkPriority(nextPage) {
+		t.Errorf("product %d should be above next page %d", DefaultLinkPriority(product), DefaultLinkPriority(nextPage))
+	}
+	if DefaultLinkPriority(seed) <= DefaultLinkPriority(shallowProduct) {
+		t.Errorf("seed %d should be above product %d", DefaultLinkPriority(seed), DefaultLinkPriority(shallowProduct))
+	}
+}
+
+func TestSitemapLink(t *testing.T) {
+	tests := []struct {
+		loc      string
+		expected string
+		ok       bool
+	}{
+		{"https://www.amazon.com/LEGO-Classic-Creative/dp/B00NHQFA1I", "https://www.amazon.com/dp/B00NHQFA1I", true},
+		{"https://amazon.com/b?node=165793011&ref_=sitemap", "https://www.amazon.com/b?node=165793011", true},
+		{"https://www.amazon.com/s?k=lego&rh=p_89%3ALEGO", "https://www.amazon.com/s?k=lego", true},
+		{"https://www.amazon.com/-/es/b?node=165793011", "", false},
+		{"https://www.amazon.com/gp/help/customer/display.html", "", false},
+	}
+
+	for _, test := range tests {
+		result, ok := sitemapLink(test.loc)
+		if result != test.expected || ok != test.ok {
+			t.Errorf("sitemapLink(%q) = %q, %v; want %q, %v", test.loc, result, ok, test.expected, test.ok)
+		}
+	}
+}
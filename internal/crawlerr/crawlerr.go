@@ -0,0 +1,87 @@
+package crawlerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Class groups crawl errors that share a cause and should be handled the same way.
+type Class string
+
+const (
+	Unknown         Class = "unknown"
+	RobotsForbidden Class = "robots_forbidden"
+	Captcha         Class = "captcha"
+	JSDisabled      Class = "js_disabled"
+	HTTPClient      Class = "http_4xx"
+	Throttled       Class = "throttled" // 403 or 429, Amazon or the proxy limits the request rate
+	HTTPServer      Class = "http_5xx"
+	Timeout         Class = "timeout"
+	Parse           Class = "parse"
+	Consumer        Class = "consumer"
+	SignIn          Class = "sign_in" // the page is only shown to signed in customers
+	Gone            Class = "gone"    // the page was removed, e.g. the product isn't sold anymore
+)
+
+var Classes = []Class{Unknown, RobotsForbidden, Captcha, JSDisabled, HTTPClient, Throttled, HTTPServer, Timeout, Parse, Consumer, SignIn, Gone}
+
+func (c Class) Valid() bool {
+	for _, class := range Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is an error that occurred while crawling an url, annotated with its class.
+type Error struct {
+	Class  Class
+	Status int // the response status for HTTPClient, Throttled and HTTPServer errors
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(class Class, err error) error {
+	return &Error{Class: class, Err: err}
+}
+
+func Newf(class Class, format string, a ...any) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, a...)}
+}
+
+// Creates an error for an unsuccessful response status.
+func HTTPStatus(status int) error {
+	class := HTTPClient
+	switch {
+	case status >= 500:
+		class = HTTPServer
+	case status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		class = Throttled
+	}
+	return &Error{Class: class, Status: status, Err: fmt.Errorf("response status %d", status)}
+}
+
+// Returns the class of err.
+// Untyped errors are classified as Timeout if they were caused by a timeout, else as Unknown.
+func ClassOf(err error) Class {
+	var crawlErr *Error
+	if errors.As(err, &crawlErr) {
+		return crawlErr.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, playwright.ErrTimeout) {
+		return Timeout
+	}
+	return Unknown
+}
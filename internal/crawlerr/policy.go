@@ -0,0 +1,134 @@
+package crawlerr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Action string
+
+const (
+	// The url is failed permanently.
+	NoRetry Action = "none"
+	// Retries with an exponentially growing delay.
+	Backoff Action = "backoff"
+	// Retries after a fixed cool-down, on a different identity than the one that failed.
+	CoolDown Action = "cooldown"
+)
+
+type Policy struct {
+	Action     Action
+	MaxRetries int           // number of retries after the first failed try
+	Delay      time.Duration // base delay of Backoff, fixed delay of CoolDown
+}
+
+// Returns when the url should be retried after it failed for the nth time.
+// The second return value is false if it shouldn't be retried at all.
+func (p Policy) NextRetry(failures int, now time.Time) (time.Time, bool) {
+	if p.Action == NoRetry || failures > p.MaxRetries {
+		return time.Time{}, false
+	}
+
+	switch p.Action {
+	case Backoff:
+		factor := math.Pow(2, float64(max(failures-1, 0)))
+		return now.Add(time.Duration(float64(p.Delay) * factor)), true
+	case CoolDown:
+		return now.Add(p.Delay), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func (p Policy) String() string {
+	if p.Action == NoRetry {
+		return string(NoRetry)
+	}
+	return fmt.Sprintf("%s:%d:%s", p.Action, p.MaxRetries, p.Delay)
+}
+
+// Policies maps each error class to its retry policy.
+type Policies map[Class]Policy
+
+// Returns the policy of the class, falling back to the policy of Unknown errors.
+func (p Policies) Get(class Class) Policy {
+	if policy, ok := p[class]; ok {
+		return policy
+	}
+	return p[Unknown]
+}
+
+func DefaultPolicies() Policies {
+	return Policies{
+		Unknown:         {Action: Backoff, MaxRetries: 2, Delay: 5 * time.Minute},
+		RobotsForbidden: {Action: NoRetry},
+		Captcha:         {Action: CoolDown, MaxRetries: 3, Delay: 30 * time.Minute},
+		JSDisabled:      {Action: CoolDown, MaxRetries: 3, Delay: 10 * time.Minute},
+		HTTPClient:      {Action: NoRetry},
+		Throttled:       {Action: Backoff, MaxRetries: 3, Delay: 10 * time.Minute},
+		HTTPServer:      {Action: Backoff, MaxRetries: 2, Delay: 5 * time.Minute},
+		Timeout:         {Action: Backoff, MaxRetries: 2, Delay: 5 * time.Minute},
+		Parse:           {Action: Backoff, MaxRetries: 2, Delay: 10 * time.Minute},
+		Consumer:        {Action: Backoff, MaxRetries: 5, Delay: time.Minute},
+		SignIn:          {Action: NoRetry},
+		Gone:            {Action: NoRetry},
+	}
+}
+
+// Parses policy overrides on top of the default policies.
+// Each entry has the format "class=none" or "class=action:maxRetries:delay",
+// e.g. "captcha=cooldown:3:30m" or "http_5xx=backoff:5:1m".
+func ParsePolicies(entries []string) (Policies, error) {
+	policies := DefaultPolicies()
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		classStr, rule, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retry policy %q", entry)
+		}
+		class := Class(strings.TrimSpace(classStr))
+		if !class.Valid() {
+			return nil, fmt.Errorf("unknown error class %q", class)
+		}
+
+		policy, err := parsePolicy(strings.TrimSpace(rule))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry policy for %s: %w", class, err)
+		}
+		policies[class] = policy
+	}
+	return policies, nil
+}
+
+func parsePolicy(rule string) (Policy, error) {
+	if rule == string(NoRetry) {
+		return Policy{Action: NoRetry}, nil
+	}
+
+	parts := strings.Split(rule, ":")
+	if len(parts) != 3 {
+		return Policy{}, fmt.Errorf("expected action:maxRetries:delay, got %q", rule)
+	}
+
+	action := Action(parts[0])
+	if action != Backoff && action != CoolDown {
+		return Policy{}, fmt.Errorf("unknown action %q", parts[0])
+	}
+	retries, err := strconv.Atoi(parts[1])
+	if err != nil || retries < 0 {
+		return Policy{}, fmt.Errorf("invalid max retries %q", parts[1])
+	}
+	delay, err := time.ParseDuration(parts[2])
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid delay %q", parts[2])
+	}
+
+	return Policy{Action: action, MaxRetries: retries, Delay: delay}, nil
+}
@@ -0,0 +1,89 @@
+package crawlerr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []string
+		class    Class
+		expected Policy
+		hasError bool
+	}{
+		{"default when empty", nil, Captcha, DefaultPolicies()[Captcha], false},
+		{"no retry", []string{"http_5xx=none"}, HTTPServer, Policy{Action: NoRetry}, false},
+		{"backoff", []string{"timeout=backoff:5:1m"}, Timeout, Policy{Action: Backoff, MaxRetries: 5, Delay: time.Minute}, false},
+		{"cooldown with spaces", []string{" captcha = cooldown:1:2h "}, Captcha, Policy{Action: CoolDown, MaxRetries: 1, Delay: 2 * time.Hour}, false},
+		{"unknown class", []string{"teapot=none"}, "", Policy{}, true},
+		{"unknown action", []string{"parse=later:1:1m"}, "", Policy{}, true},
+		{"missing delay", []string{"parse=backoff:1"}, "", Policy{}, true},
+		{"invalid delay", []string{"parse=backoff:1:soon"}, "", Policy{}, true},
+		{"missing rule", []string{"parse"}, "", Policy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := ParsePolicies(tt.entries)
+			gotErr := err != nil
+			if gotErr != tt.hasError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.hasError {
+				return
+			}
+			if got := policies.Get(tt.class); got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNextRetry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		expected time.Duration
+		retry    bool
+	}{
+		{"no retry", Policy{Action: NoRetry}, 1, 0, false},
+		{"first backoff", Policy{Action: Backoff, MaxRetries: 3, Delay: 5 * time.Minute}, 1, 5 * time.Minute, true},
+		{"third backoff", Policy{Action: Backoff, MaxRetries: 3, Delay: 5 * time.Minute}, 3, 20 * time.Minute, true},
+		{"retries exhausted", Policy{Action: Backoff, MaxRetries: 3, Delay: 5 * time.Minute}, 4, 0, false},
+		{"cooldown is fixed", Policy{Action: CoolDown, MaxRetries: 3, Delay: time.Hour}, 3, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, ok := tt.policy.NextRetry(tt.failures, now)
+			if ok != tt.retry {
+				t.Fatalf("got retry %v, want %v", ok, tt.retry)
+			}
+			if ok && at.Sub(now) != tt.expected {
+				t.Errorf("got delay %s, want %s", at.Sub(now), tt.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPStatusClass(t *testing.T) {
+	tests := map[int]Class{
+		400: HTTPClient,
+		403: Throttled,
+		410: HTTPClient,
+		429: Throttled,
+		503: HTTPServer,
+	}
+	for status, want := range tests {
+		if got := ClassOf(HTTPStatus(status)); got != want {
+			t.Errorf("ClassOf(HTTPStatus(%d)) = %s; want %s", status, got, want)
+		}
+		if _, retried := DefaultPolicies().Get(want).NextRetry(1, time.Now()); retried != (want != HTTPClient) {
+			t.Errorf("status %d retried = %t", status, retried)
+		}
+	}
+}
@@ -14,27 +14,17 @@ import (
 )
 
 type Options struct {
-	Proxy     string
-	ProxyPW   string
-	ProxyUser string
+	// Selects the proxy robots.txt is requested through, nil for direct requests
+	Proxy func(*http.Request) (*url.URL, error)
 }
 
 func NewRobotsChecker(opts Options) *RobotsChecker {
 	log := internal.NewLogger("RobotsChecker")
 	transport := &http.Transport{}
 
-	if opts.Proxy != "" {
-		log.Debug("using proxy", slog.String("host", opts.Proxy))
-		proxyURL, err := url.Parse(opts.Proxy)
-		if err == nil {
-			if opts.ProxyUser != "" && opts.ProxyPW != "" {
-				log.Debug("using proxy username and password")
-				proxyURL.User = url.UserPassword(opts.ProxyUser, opts.ProxyPW)
-			}
-			transport.Proxy = http.ProxyURL(proxyURL)
-		} else {
-			slog.Warn("failed to parse proxy", slog.String("host", opts.Proxy))
-		}
+	if opts.Proxy != nil {
+		log.Debug("using proxy")
+		transport.Proxy = opts.Proxy
 	}
 
 	return &RobotsChecker{
@@ -63,20 +53,10 @@ func (r *RobotsChecker) Check(rawURL string, ua string) error {
 		return nil // error but allow access
 	}
 
-	r.mut.RLock()
-	robotsData, exists := r.robotsMap[parsed.Host]
-	r.mut.RUnlock()
-
-	if !exists {
-		robotsData, err = r.getRobotsData(parsed)
-		if err != nil {
-			r.log.Error("reading robots.txt", internal.ErrAttr(err))
-			return nil // error but allow access
-		}
-
-		r.mut.Lock()
-		r.robotsMap[parsed.Host] = robotsData
-		r.mut.Unlock()
+	robotsData, err := r.robots(parsed)
+	if err != nil {
+		r.log.Error("reading robots.txt", internal.ErrAttr(err))
+		return nil // error but allow access
 	}
 
 	if !robotsData.TestAgent(parsed.Path, ua) {
@@ -85,6 +65,39 @@ func (r *RobotsChecker) Check(rawURL string, ua string) error {
 	return nil
 }
 
+// Returns the sitemaps listed in the robots.txt of the url's host.
+func (r *RobotsChecker) Sitemaps(rawURL string) ([]string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	robotsData, err := r.robots(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("reading robots.txt: %w", err)
+	}
+	return robotsData.Sitemaps, nil
+}
+
+// Returns the robots.txt of the url's host, downloading it on first use.
+func (r *RobotsChecker) robots(parsed *url.URL) (*robotstxt.RobotsData, error) {
+	r.mut.RLock()
+	robotsData, exists := r.robotsMap[parsed.Host]
+	r.mut.RUnlock()
+	if exists {
+		return robotsData, nil
+	}
+
+	robotsData, err := r.getRobotsData(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mut.Lock()
+	r.robotsMap[parsed.Host] = robotsData
+	r.mut.Unlock()
+	return robotsData, nil
+}
+
 func (r *RobotsChecker) getRobotsData(url *url.URL) (*robotstxt.RobotsData, error) {
 	requestURL := url.Scheme + "://" + url.Host + "/robots.txt"
 	resp, err := r.client.Get(requestURL)
@@ -41,6 +41,17 @@ type Product struct {
 	SellerID               string       `json:"sellerId,omitempty"`
 	FirstAvailableAt       *time.Time   `json:"firstAvailableAt,omitempty"` // needs to be pointer, else won't be omitted if empty
 	BoughtPastMonth        int          `json:"boughtPastMonth,omitempty"`
+	Location               string       `json:"location,omitempty"`    // the delivery ZIP code prices and availability apply to
+	Keyword                string       `json:"keyword,omitempty"`     // the tag of the keyword search the product was found through
+	Marketplace            string       `json:"marketplace,omitempty"` // the host the product was crawled on, e.g. www.amazon.de
+}
+
+// Emitted for a product whose page doesn't exist anymore, so indexes can delist it.
+type Tombstone struct {
+	ASIN        string    `json:"asin"`
+	Marketplace string    `json:"marketplace,omitempty"` // only the product of this marketplace is gone
+	URL         string    `json:"url"`
+	GoneAt      time.Time `json:"goneAt"`
 }
 
 type BestSeller struct {
@@ -743,7 +754,7 @@ func parseInt(text string) (int, error) {
 }
 
 func AsinFromURL(url string) (string, error) {
-	re := regexp.MustCompile(`dp(?:\/|%2[Ff])([A-Z0-9]{10})`)
+	re := regexp.MustCompile(`(?:dp|gp(?:\/|%2[Ff])product|gp(?:\/|%2[Ff])aw(?:\/|%2[Ff])d)(?:\/|%2[Ff])([A-Z0-9]{10})`)
 	matches := re.FindStringSubmatch(url)
 	if len(matches) > 1 {
 		return strings.TrimSpace(matches[1]), nil
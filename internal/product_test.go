@@ -59,6 +59,8 @@ func TestGetAsinFromURL(t *testing.T) {
 		{"https://www.amazon.com/dp/B07984JN3L", "B07984JN3L", false},
 		{"https://www.amazon.com/dp/B0DK7B7G9R", "B0DK7B7G9R", false},
 		{"/sspa/click?url=%2FCoogam-Educational%2Fdp%2FB09Q82N7DN%3Fpsc%3D1", "B09Q82N7DN", false},
+		{"https://www.amazon.com/gp/product/B07984JN3L/ref=ox_sc", "B07984JN3L", false},
+		{"/gp/aw/d/B07984JN3L", "B07984JN3L", false},
 		{"/dp/", "", true},
 		{"https://amazon.com/super-nice-book", "", true},
 		{"https://amazon.com", "", true},
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+type Outcome string
+
+const (
+	Success   Outcome = "success"
+	Captcha   Outcome = "captcha"
+	Timeout   Outcome = "timeout"
+	HTTPError Outcome = "http_error"
+)
+
+// The weight of the newest outcome in the health score.
+const scoreDecay = 0.1
+
+type HealthOptions struct {
+	MaxFailures    int           // consecutive failures that quarantine a proxy
+	MinScore       float64       // proxies scoring below are quarantined
+	MinSamples     int           // outcomes needed before the score is trusted
+	QuarantineBase time.Duration // cool-down of the first quarantine, doubled on each following one
+	QuarantineMax  time.Duration
+	HealthURL      string        // quarantined proxies must fetch this url before being used again
+	HealthInterval time.Duration // how often quarantined proxies are probed
+}
+
+// Tracks the outcomes of the requests made through a single proxy.
+type health struct {
+	successes        int
+	captchas         int
+	timeouts         int
+	httpErrors       int
+	latency          time.Duration // moving average of successful requests
+	score            float64       // moving average of successes, 1 is perfectly healthy
+	failures         int           // consecutive failures
+	quarantines      int
+	quarantinedUntil time.Time
+	awaitingProbe    bool // the cool-down passed but the proxy didn't pass a probe yet
+}
+
+type Stats struct {
+	Server           string     `json:"server"`
+	Score            float64    `json:"score"`
+	Successes        int        `json:"successes"`
+	Captchas         int        `json:"captchas"`
+	Timeouts         int        `json:"timeouts"`
+	HTTPErrors       int        `json:"httpErrors"`
+	AvgLatencyMs     int64      `json:"avgLatencyMs"`
+	Quarantined      bool       `json:"quarantined"`
+	QuarantinedUntil *time.Time `json:"quarantinedUntil,omitempty"`
+	Quarantines      int        `json:"quarantines"`
+}
+
+func (h *health) samples() int {
+	return h.successes + h.captchas + h.timeouts + h.httpErrors
+}
+
+// Records the outcome of a request made through the proxy.
+func (pm *proxyManager) Record(p Proxy, outcome Outcome, latency time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	i := pm.indexOf(p)
+	if i < 0 {
+		return
+	}
+	h := &pm.health[i]
+	// checked before counting, an ended quarantine resets the failures
+	inQuarantine := pm.quarantined(i)
+
+	switch outcome {
+	case Success:
+		h.successes++
+		h.failures = 0
+		h.score += scoreDecay * (1 - h.score)
+		if h.latency == 0 {
+			h.latency = latency
+		} else {
+			h.latency += time.Duration(scoreDecay * float64(latency-h.latency))
+		}
+		return
+	case Captcha:
+		h.captchas++
+	case Timeout:
+		h.timeouts++
+	case HTTPError:
+		h.httpErrors++
+	}
+	h.failures++
+	h.score -= scoreDecay * h.score
+
+	if inQuarantine {
+		return
+	}
+	if h.failures >= pm.MaxFailures || (h.samples() >= pm.MinSamples && h.score < pm.MinScore) {
+		pm.quarantine(i)
+	}
+}
+
+// must be called with mu held
+func (pm *proxyManager) quarantine(i int) {
+	h := &pm.health[i]
+	h.quarantines++
+	coolDown := time.Duration(float64(pm.QuarantineBase) * math.Pow(2, float64(h.quarantines-1)))
+	coolDown = min(coolDown, pm.QuarantineMax)
+	h.quarantinedUntil = pm.now().Add(coolDown)
+	h.awaitingProbe = pm.HealthURL != ""
+	pm.log.Warn(fmt.Sprintf("quarantined proxy for %s", coolDown),
+		slog.String("proxy", pm.proxies[i].Server),
+		slog.Float64("score", h.score),
+		slog.Int("failures", h.failures),
+	)
+}
+
+// must be called with mu held
+func (pm *proxyManager) release(i int) {
+	h := &pm.health[i]
+	h.failures = 0
+	h.awaitingProbe = false
+	h.quarantinedUntil = time.Time{}
+	// give the proxy a fresh chance, else the old failures quarantine it again right away
+	h.score = max(h.score, pm.MinScore+scoreDecay)
+	pm.log.Info("released proxy from quarantine", slog.String("proxy", pm.proxies[i].Server))
+}
+
+// Without a health url, a proxy is released once its cool-down passed. Like a half-open
+// circuit breaker, its old failures are forgotten and a new failure streak quarantines it again.
+// must be called with mu held
+func (pm *proxyManager) quarantined(i int) bool {
+	h := &pm.health[i]
+	if h.awaitingProbe {
+		return true
+	}
+	if h.quarantinedUntil.IsZero() {
+		return false
+	}
+	if pm.now().Before(h.quarantinedUntil) {
+		return true
+	}
+	pm.release(i)
+	return false
+}
+
+// Returns the health statistics of every proxy in the pool.
+func (pm *proxyManager) Stats() []Stats {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	stats := make([]Stats, len(pm.proxies))
+	for i, p := range pm.proxies {
+		h := pm.health[i]
+		s := Stats{
+			Server:       p.Server,
+			Score:        h.score,
+			Successes:    h.successes,
+			Captchas:     h.captchas,
+			Timeouts:     h.timeouts,
+			HTTPErrors:   h.httpErrors,
+			AvgLatencyMs: h.latency.Milliseconds(),
+			Quarantined:  pm.quarantined(i),
+			Quarantines:  h.quarantines,
+		}
+		if s.Quarantined {
+			until := h.quarantinedUntil
+			s.QuarantinedUntil = &until
+		}
+		stats[i] = s
+	}
+	return stats
+}
+
+// Periodically probes the quarantined proxies whose cool-down passed, until ctx is done.
+// Proxies that fetch the health url are released, the others are quarantined again.
+func (pm *proxyManager) StartHealthChecks(ctx context.Context) {
+	if pm.HealthURL == "" || len(pm.proxies) == 0 {
+		return
+	}
+
+	pm.log.Info(fmt.Sprintf("probing quarantined proxies every %s", pm.HealthInterval), slog.String("url", pm.HealthURL))
+	ticker := time.NewTicker(pm.HealthInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pm.probeQuarantined(ctx)
+			}
+		}
+	}()
+}
+
+func (pm *proxyManager) probeQuarantined(ctx context.Context) {
+	pm.mu.Lock()
+	var due []int
+	for i := range pm.proxies {
+		h := pm.health[i]
+		if h.awaitingProbe && !pm.now().Before(h.quarantinedUntil) {
+			due = append(due, i)
+		}
+	}
+	pm.mu.Unlock()
+
+	for _, i := range due {
+		err := pm.probe(ctx, pm.proxies[i])
+
+		pm.mu.Lock()
+		if err != nil {
+			pm.log.Debug("proxy probe failed", slog.String("proxy", pm.proxies[i].Server), internal.ErrAttr(err))
+			pm.quarantine(i)
+		} else {
+			pm.release(i)
+		}
+		pm.mu.Unlock()
+	}
+}
+
+func (pm *proxyManager) probe(ctx context.Context, p Proxy) error {
+	proxyURL, err := p.URL()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   15 * time.Second,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pm.HealthURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("health check status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -1,44 +1,348 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jonashiltl/amazon-crawler/internal"
 	"github.com/playwright-community/playwright-go"
 )
 
+type Strategy string
+
+const (
+	RoundRobin        Strategy = "round-robin"
+	Random            Strategy = "random"
+	LeastRecentlyUsed Strategy = "lru"
+	Sticky            Strategy = "sticky" // the same session always gets the same proxy
+)
+
+// A Pool hands out proxies by its rotation strategy, skipping quarantined proxies.
+type Pool interface {
+	// Returns the next proxy. The session is only used by the Sticky strategy.
+	Next(session string) (Proxy, error)
+	// Forgets the proxy assigned to the session, once the session won't be used again.
+	Release(session string)
+	// Records the outcome of a request, which is used to quarantine unhealthy proxies.
+	Record(p Proxy, outcome Outcome, latency time.Duration)
+	Stats() []Stats
+	// Probes quarantined proxies in the background until ctx is done.
+	StartHealthChecks(ctx context.Context)
+	Len() int
+}
+
+type Proxy struct {
+	Server   string // e.g. http://host:port
+	Username string
+	Password string
+}
+
+func (p Proxy) Playwright() playwright.Proxy {
+	pp := playwright.Proxy{Server: p.Server}
+	if p.Username != "" {
+		pp.Username = playwright.String(p.Username)
+		pp.Password = playwright.String(p.Password)
+	}
+	return pp
+}
+
+// Returns the proxy url including the credentials.
+func (p Proxy) URL() (*url.URL, error) {
+	u, err := url.Parse(p.Server)
+	if err != nil {
+		return nil, err
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
 type proxyManager struct {
 	Options
-	mu    sync.Mutex
-	index int
+	proxies  []Proxy
+	log      *slog.Logger
+	mu       sync.Mutex
+	index    int
+	lastUsed []time.Time
+	health   []health
+	sessions map[string]int // index of the proxy assigned to a session
+	now      func() time.Time
 }
 
 type Options struct {
-	Proxies  []string
-	Username string
+	HealthOptions
+	Proxies  []string // in the format host:port, host:port:user:pass or scheme://[user:pass@]host:port
+	Username string   // used for proxies without credentials
 	Password string
+	Strategy Strategy
 }
 
-func NewProxyManager(opts Options) *proxyManager {
+func NewProxyManager(opts Options) (*proxyManager, error) {
+	if opts.Strategy == "" {
+		opts.Strategy = RoundRobin
+	}
+	switch opts.Strategy {
+	case RoundRobin, Random, LeastRecentlyUsed, Sticky:
+	default:
+		return nil, fmt.Errorf("unknown proxy strategy %q", opts.Strategy)
+	}
+
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 5
+	}
+	if opts.MinScore <= 0 {
+		opts.MinScore = 0.3
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 10
+	}
+	if opts.QuarantineBase <= 0 {
+		opts.QuarantineBase = 5 * time.Minute
+	}
+	if opts.QuarantineMax <= 0 {
+		opts.QuarantineMax = 6 * time.Hour
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = time.Minute
+	}
+
+	proxies := make([]Proxy, 0, len(opts.Proxies))
+	for _, raw := range opts.Proxies {
+		p, err := Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if p.Username == "" {
+			p.Username = opts.Username
+			p.Password = opts.Password
+		}
+		proxies = append(proxies, p)
+	}
+
+	healths := make([]health, len(proxies))
+	for i := range healths {
+		healths[i].score = 1
+	}
+
 	return &proxyManager{
-		Options: opts,
+		Options:  opts,
+		proxies:  proxies,
+		log:      internal.NewLogger("ProxyManager"),
+		lastUsed: make([]time.Time, len(proxies)),
+		health:   healths,
+		sessions: make(map[string]int),
+		now:      time.Now,
+	}, nil
+}
+
+func (pm *proxyManager) Len() int {
+	return len(pm.proxies)
+}
+
+func (pm *proxyManager) Next(session string) (Proxy, error) {
+	switch pm.Strategy {
+	case Random:
+		return pm.Random()
+	case LeastRecentlyUsed:
+		return pm.LeastRecentlyUsed()
+	case Sticky:
+		return pm.Sticky(session)
+	default:
+		return pm.RoundRobin()
 	}
 }
 
-func (pm *proxyManager) RoundRobin() (playwright.Proxy, error) {
+func (pm *proxyManager) RoundRobin() (Proxy, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	if len(pm.Proxies) == 0 {
-		return playwright.Proxy{}, errors.New("no proxies available")
+	if len(pm.proxies) == 0 {
+		return Proxy{}, errors.New("no proxies available")
 	}
 
-	proxyURL := pm.Proxies[pm.index]
-	pm.index = (pm.index + 1) % len(pm.Proxies)
+	return pm.use(pm.nextIndex()), nil
+}
 
-	return playwright.Proxy{
-		Server:   proxyURL,
-		Username: playwright.String(pm.Username),
-		Password: playwright.String(pm.Password),
-	}, nil
+func (pm *proxyManager) Random() (Proxy, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if len(pm.proxies) == 0 {
+		return Proxy{}, errors.New("no proxies available")
+	}
+
+	available := pm.available()
+	return pm.use(available[rand.IntN(len(available))]), nil
+}
+
+func (pm *proxyManager) LeastRecentlyUsed() (Proxy, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if len(pm.proxies) == 0 {
+		return Proxy{}, errors.New("no proxies available")
+	}
+
+	available := pm.available()
+	oldest := available[0]
+	for _, i := range available {
+		if pm.lastUsed[i].Before(pm.lastUsed[oldest]) {
+			oldest = i
+		}
+	}
+	return pm.use(oldest), nil
+}
+
+// Returns the proxy assigned to the session.
+// New sessions, and sessions whose proxy got quarantined, are assigned proxies round-robin.
+func (pm *proxyManager) Sticky(session string) (Proxy, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if len(pm.proxies) == 0 {
+		return Proxy{}, errors.New("no proxies available")
+	}
+
+	i, ok := pm.sessions[session]
+	if !ok || pm.quarantined(i) {
+		i = pm.nextIndex()
+		pm.sessions[session] = i
+	}
+	return pm.use(i), nil
+}
+
+func (pm *proxyManager) Release(session string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.sessions, session)
+}
+
+// Returns the next proxy in order that isn't quarantined.
+// must be called with mu held
+func (pm *proxyManager) nextIndex() int {
+	for range pm.proxies {
+		i := pm.index
+		pm.index = (pm.index + 1) % len(pm.proxies)
+		if !pm.quarantined(i) {
+			return i
+		}
+	}
+	return pm.soonestReleased()
+}
+
+// Returns the indexes of all proxies that aren't quarantined.
+// must be called with mu held
+func (pm *proxyManager) available() []int {
+	var available []int
+	for i := range pm.proxies {
+		if !pm.quarantined(i) {
+			available = append(available, i)
+		}
+	}
+	if len(available) == 0 {
+		return []int{pm.soonestReleased()}
+	}
+	return available
+}
+
+// When every proxy is quarantined, the one whose cool-down ends first is used,
+// since stopping the crawler altogether is worse than using an unhealthy proxy.
+// must be called with mu held
+func (pm *proxyManager) soonestReleased() int {
+	soonest := 0
+	for i := range pm.proxies {
+		if pm.health[i].quarantinedUntil.Before(pm.health[soonest].quarantinedUntil) {
+			soonest = i
+		}
+	}
+	pm.log.Warn("all proxies are quarantined", slog.String("proxy", pm.proxies[soonest].Server))
+	return soonest
+}
+
+// must be called with mu held
+func (pm *proxyManager) indexOf(p Proxy) int {
+	for i, candidate := range pm.proxies {
+		if candidate == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// must be called with mu held
+func (pm *proxyManager) use(i int) Proxy {
+	pm.lastUsed[i] = time.Now()
+	return pm.proxies[i]
+}
+
+// Parses a proxy in the format host:port, host:port:user:pass or scheme://[user:pass@]host:port.
+// Proxies without scheme use http.
+func Parse(raw string) (Proxy, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Proxy{}, errors.New("empty proxy")
+	}
+
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return Proxy{}, fmt.Errorf("invalid proxy url %q: %w", raw, err)
+		}
+		if u.Host == "" {
+			return Proxy{}, fmt.Errorf("proxy %q has no host", raw)
+		}
+		p := Proxy{Server: u.Scheme + "://" + u.Host}
+		if u.User != nil {
+			p.Username = u.User.Username()
+			p.Password, _ = u.User.Password()
+		}
+		return p, nil
+	}
+
+	parts := strings.Split(raw, ":")
+	switch len(parts) {
+	case 2:
+		return Proxy{Server: "http://" + raw}, nil
+	case 4:
+		return Proxy{
+			Server:   "http://" + parts[0] + ":" + parts[1],
+			Username: parts[2],
+			Password: parts[3],
+		}, nil
+	default:
+		return Proxy{}, fmt.Errorf("invalid proxy %q, expected host:port or host:port:user:pass", raw)
+	}
+}
+
+// Reads one proxy per line. Empty lines and lines starting with # are skipped.
+func LoadFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open proxy file: %w", err)
+	}
+	defer f.Close()
+
+	var proxies []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxies = append(proxies, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read proxy file: %w", err)
+	}
+	return proxies, nil
 }
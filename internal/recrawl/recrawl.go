@@ -0,0 +1,125 @@
+package recrawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+type Options struct {
+	MinInterval time.Duration            // products are never revisited sooner
+	MaxInterval time.Duration            // products are revisited at the latest after this long
+	Categories  map[string]time.Duration // fixed intervals of categories, bypassing the estimate
+}
+
+// The visits of a product page so far.
+type History struct {
+	Visits   int           // number of visits, including the current one
+	Changes  int           // number of visits that found the product changed since the previous visit
+	Observed time.Duration // time between the first and the current visit
+}
+
+// Decides when product pages are visited again.
+type Scheduler struct {
+	opts Options
+}
+
+func NewScheduler(opts Options) (*Scheduler, error) {
+	if opts.MinInterval <= 0 {
+		return nil, fmt.Errorf("minimum recrawl interval must be positive, got %s", opts.MinInterval)
+	}
+	if opts.MaxInterval < opts.MinInterval {
+		return nil, fmt.Errorf("maximum recrawl interval %s is below the minimum %s", opts.MaxInterval, opts.MinInterval)
+	}
+	return &Scheduler{opts: opts}, nil
+}
+
+// Returns how long to wait before visiting the product again.
+// The most specific of the product's categories with an override decides, otherwise
+// the interval is the expected time between changes, bounded by the min and max interval.
+func (s *Scheduler) Interval(categories []string, h History) time.Duration {
+	for i := len(categories) - 1; i >= 0; i-- {
+		if interval, ok := s.opts.Categories[categories[i]]; ok {
+			return interval
+		}
+	}
+
+	// revisit soon until there's a history to learn from
+	if h.Visits < 2 || h.Observed <= 0 {
+		return s.opts.MinInterval
+	}
+
+	rate := ChangeRate(h)
+	if rate <= 0 {
+		return s.opts.MaxInterval
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return min(max(interval, s.opts.MinInterval), s.opts.MaxInterval)
+}
+
+// Estimates the changes per second, assuming changes follow a Poisson process.
+// Visits only reveal whether the product changed at least once since the previous visit,
+// the estimator of Cho and Garcia-Molina corrects for the changes missed in between:
+//
+//	λ = -ln((n - X + 0.5) / (n + 0.5)) / I
+//
+// where n is the number of revisits, X the number of detected changes and I the mean time between visits.
+func ChangeRate(h History) float64 {
+	n := float64(h.Visits - 1)
+	if n <= 0 || h.Observed <= 0 {
+		return 0
+	}
+	x := math.Min(float64(h.Changes), n)
+	meanInterval := h.Observed.Seconds() / n
+	return -math.Log((n-x+0.5)/(n+0.5)) / meanInterval
+}
+
+// Hashes the attributes of a product that are expected to change, e.g. its price and rank.
+// Two visits with a different fingerprint count as a change.
+func Fingerprint(p internal.Product) string {
+	b, _ := json.Marshal(struct {
+		Title           string
+		AverageRating   float32
+		Ratings         int
+		IsAmazonChoice  bool
+		BestSellers     []internal.BestSeller
+		ListPrice       float32
+		DiscountedPrice float32
+		Currency        string
+		SellerID        string
+		BoughtPastMonth int
+	}{
+		p.Title, p.AverageRating, p.Ratings, p.IsAmazonChoice, p.BestSellers,
+		p.ListPrice, p.DiscountedPrice, p.Currency, p.SellerID, p.BoughtPastMonth,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Parses fixed intervals of categories with the format "category=interval", e.g. "Baby=24h".
+func ParseCategories(entries []string) (map[string]time.Duration, error) {
+	categories := make(map[string]time.Duration)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		category, intervalStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid category interval %q, expected category=interval", entry)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(intervalStr))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval of category %q: %q", category, intervalStr)
+		}
+		categories[strings.TrimSpace(category)] = interval
+	}
+	return categories, nil
+}
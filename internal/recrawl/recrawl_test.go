@@ -0,0 +1,113 @@
+package recrawl
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+func TestChangeRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  History
+		expected float64 // changes per day
+	}{
+		{"first visit", History{Visits: 1}, 0},
+		{"never changed", History{Visits: 11, Changes: 0, Observed: 10 * 24 * time.Hour}, 0},
+		// -ln(5.5/10.5) per day
+		{"changed half of the visits", History{Visits: 11, Changes: 5, Observed: 10 * 24 * time.Hour}, 0.6466},
+		// -ln(0.5/10.5) per day, more than one change per day is likely missed
+		{"changed every visit", History{Visits: 11, Changes: 10, Observed: 10 * 24 * time.Hour}, 3.0445},
+		{"more changes than revisits", History{Visits: 11, Changes: 12, Observed: 10 * 24 * time.Hour}, 3.0445},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			perDay := ChangeRate(test.history) * (24 * time.Hour).Seconds()
+			if math.Abs(perDay-test.expected) > 0.001 {
+				t.Errorf("ChangeRate() = %.4f per day; want %.4f", perDay, test.expected)
+			}
+		})
+	}
+}
+
+func TestInterval(t *testing.T) {
+	s, err := NewScheduler(Options{
+		MinInterval: 6 * time.Hour,
+		MaxInterval: 30 * 24 * time.Hour,
+		Categories: map[string]time.Duration{
+			"Toys & Games": 48 * time.Hour,
+			"Puzzles":      12 * time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		categories []string
+		history    History
+		expected   time.Duration
+	}{
+		{"first visit", nil, History{Visits: 1}, 6 * time.Hour},
+		{"never changed", nil, History{Visits: 5, Observed: 4 * 24 * time.Hour}, 30 * 24 * time.Hour},
+		{"changed every visit", nil, History{Visits: 5, Changes: 4, Observed: 4 * time.Hour}, 6 * time.Hour},
+		// -ln(2.5/4.5) = 0.5878 changes per day
+		{"changed sometimes", nil, History{Visits: 5, Changes: 2, Observed: 4 * 24 * time.Hour}, 146993 * time.Second},
+		{"category override", []string{"Toys & Games", "Building Toys"}, History{Visits: 1}, 48 * time.Hour},
+		{"most specific category", []string{"Toys & Games", "Puzzles"}, History{Visits: 1}, 12 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := s.Interval(test.categories, test.history)
+			if (result - test.expected).Abs() > time.Second {
+				t.Errorf("Interval() = %s; want %s", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestNewSchedulerValidates(t *testing.T) {
+	if _, err := NewScheduler(Options{}); err == nil {
+		t.Error("expected error for missing minimum interval")
+	}
+	if _, err := NewScheduler(Options{MinInterval: time.Hour, MaxInterval: time.Minute}); err == nil {
+		t.Error("expected error for maximum below minimum")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	p := internal.Product{ASIN: "B00NHQFA1I", Title: "LEGO Classic", DiscountedPrice: 29.99}
+
+	same := p
+	same.Images = []string{"https://m.media-amazon.com/images/I/1.jpg"}
+	if Fingerprint(p) != Fingerprint(same) {
+		t.Error("fingerprint changed by an attribute that isn't tracked")
+	}
+
+	changed := p
+	changed.DiscountedPrice = 24.99
+	if Fingerprint(p) == Fingerprint(changed) {
+		t.Error("fingerprint didn't change with the price")
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories([]string{"Toys & Games=48h", " Baby = 24h ", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if categories["Toys & Games"] != 48*time.Hour || categories["Baby"] != 24*time.Hour || len(categories) != 2 {
+		t.Errorf("ParseCategories() = %v", categories)
+	}
+
+	for _, invalid := range []string{"Baby", "Baby=daily", "Baby=-1h"} {
+		if _, err := ParseCategories([]string{invalid}); err == nil {
+			t.Errorf("ParseCategories(%q) expected error", invalid)
+		}
+	}
+}
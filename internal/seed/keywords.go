@@ -0,0 +1,83 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// A search the crawl starts from.
+type Keyword struct {
+	Keyword   string `yaml:"keyword" json:"keyword"`
+	Alias     string `yaml:"alias" json:"alias"`           // search alias of the department, e.g. toys-and-games, empty searches all departments
+	FirstPage int    `yaml:"first_page" json:"first_page"` // defaults to 1
+	LastPage  int    `yaml:"last_page" json:"last_page"`   // defaults to the first page, later pages are found by pagination
+	Tag       string `yaml:"tag" json:"tag"`               // carried to the pages and products found through the keyword, defaults to the keyword
+}
+
+// Reads the keywords from a yaml or json file with a top level "keywords" list.
+func LoadKeywords(path string) ([]Keyword, error) {
+	var file struct {
+		Keywords []Keyword `yaml:"keywords" json:"keywords"`
+	}
+	if err := cleanenv.ReadConfig(path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read keywords %s: %w", path, err)
+	}
+
+	var errs []error
+	for i := range file.Keywords {
+		if err := file.Keywords[i].normalize(); err != nil {
+			errs = append(errs, fmt.Errorf("keyword %d: %w", i+1, err))
+		}
+	}
+	return file.Keywords, errors.Join(errs...)
+}
+
+func (k *Keyword) normalize() error {
+	k.Keyword = strings.TrimSpace(k.Keyword)
+	if k.Keyword == "" {
+		return errors.New("missing keyword")
+	}
+	if k.FirstPage <= 0 {
+		k.FirstPage = 1
+	}
+	if k.LastPage <= 0 {
+		k.LastPage = k.FirstPage
+	}
+	if k.LastPage < k.FirstPage {
+		return fmt.Errorf("last page %d of %q is before the first page %d", k.LastPage, k.Keyword, k.FirstPage)
+	}
+	if k.Tag == "" {
+		k.Tag = k.Keyword
+	}
+	return nil
+}
+
+// Returns the search urls of the keyword's pages on each marketplace, e.g. www.amazon.com or www.amazon.de.
+func (k Keyword) URLs(marketplaces []string) []string {
+	urls := make([]string, 0, len(marketplaces)*(k.LastPage-k.FirstPage+1))
+	for _, marketplace := range marketplaces {
+		for page := k.FirstPage; page <= k.LastPage; page++ {
+			q := url.Values{}
+			q.Set("k", k.Keyword)
+			if k.Alias != "" {
+				q.Set("i", k.Alias)
+			}
+			if page > 1 {
+				q.Set("page", strconv.Itoa(page))
+			}
+			u := url.URL{
+				Scheme:   "https",
+				Host:     marketplace,
+				Path:     "/s",
+				RawQuery: q.Encode(),
+			}
+			urls = append(urls, u.String())
+		}
+	}
+	return urls
+}
@@ -0,0 +1,86 @@
+package seed
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestKeywordURLs(t *testing.T) {
+	tests := []struct {
+		name         string
+		keyword      Keyword
+		marketplaces []string
+		expected     []string
+	}{
+		{
+			name:         "first page",
+			keyword:      Keyword{Keyword: "lego", FirstPage: 1, LastPage: 1},
+			marketplaces: []string{"www.amazon.com"},
+			expected:     []string{"https://www.amazon.com/s?k=lego"},
+		},
+		{
+			name:         "page range in a department",
+			keyword:      Keyword{Keyword: "lego star wars", Alias: "toys-and-games", FirstPage: 2, LastPage: 3},
+			marketplaces: []string{"www.amazon.com"},
+			expected: []string{
+				"https://www.amazon.com/s?i=toys-and-games&k=lego+star+wars&page=2",
+				"https://www.amazon.com/s?i=toys-and-games&k=lego+star+wars&page=3",
+			},
+		},
+		{
+			name:         "marketplaces",
+			keyword:      Keyword{Keyword: "lego", FirstPage: 1, LastPage: 1},
+			marketplaces: []string{"www.amazon.com", "www.amazon.de"},
+			expected:     []string{"https://www.amazon.com/s?k=lego", "https://www.amazon.de/s?k=lego"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.keyword.URLs(test.marketplaces); !slices.Equal(result, test.expected) {
+				t.Errorf("URLs() = %v; want %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadKeywords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keywords.yaml")
+	err := os.WriteFile(path, []byte(`
+keywords:
+  - keyword: " lego "
+  - keyword: duplo
+    alias: toys-and-games
+    first_page: 2
+    last_page: 4
+    tag: toddlers
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keywords, err := LoadKeywords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Keyword{
+		{Keyword: "lego", FirstPage: 1, LastPage: 1, Tag: "lego"},
+		{Keyword: "duplo", Alias: "toys-and-games", FirstPage: 2, LastPage: 4, Tag: "toddlers"},
+	}
+	if !slices.Equal(keywords, expected) {
+		t.Errorf("LoadKeywords() = %+v; want %+v", keywords, expected)
+	}
+}
+
+func TestLoadKeywordsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keywords.json")
+	err := os.WriteFile(path, []byte(`{"keywords": [{"keyword": ""}, {"keyword": "lego", "first_page": 3, "last_page": 2}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeywords(path); err == nil {
+		t.Error("expected errors for the missing keyword and the page range")
+	}
+}
@@ -0,0 +1,183 @@
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+// An url of a sitemap, or a sitemap of a sitemap index.
+type Entry struct {
+	Loc     string
+	LastMod time.Time // zero if the sitemap doesn't say
+}
+
+type xmlEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// Reads a sitemap or sitemap index, gzipped or plain, without loading it into memory.
+// Calls onURL for each url of a sitemap and onSitemap for each sitemap of an index.
+// Stops at the first error returned by a callback.
+func Parse(r io.Reader, onURL func(Entry) error, onSitemap func(Entry) error) error {
+	br := bufio.NewReader(r)
+	// gzipped sitemaps aren't always served with a gzip content encoding
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("reading gzipped sitemap: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading sitemap: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var callback func(Entry) error
+		switch start.Name.Local {
+		case "url":
+			callback = onURL
+		case "sitemap":
+			callback = onSitemap
+		default:
+			continue
+		}
+
+		var e xmlEntry
+		if err := decoder.DecodeElement(&e, &start); err != nil {
+			return fmt.Errorf("reading sitemap entry: %w", err)
+		}
+		loc := strings.TrimSpace(e.Loc)
+		if loc == "" || callback == nil {
+			continue
+		}
+		if err := callback(Entry{Loc: loc, LastMod: parseLastMod(e.LastMod)}); err != nil {
+			return err
+		}
+	}
+}
+
+// lastmod uses the W3C datetime format, a subset of ISO 8601
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type Options struct {
+	// Selects the proxy sitemaps are requested through, nil for direct requests
+	Proxy    func(*http.Request) (*url.URL, error)
+	MaxDepth int // nesting of sitemap indexes that is followed
+}
+
+// Downloads sitemaps and the sitemaps of sitemap indexes.
+type Fetcher struct {
+	opts   Options
+	client *http.Client
+	log    *slog.Logger
+}
+
+func NewFetcher(opts Options) *Fetcher {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = 3
+	}
+	return &Fetcher{
+		opts: opts,
+		client: &http.Client{
+			Transport: &http.Transport{Proxy: opts.Proxy},
+			Timeout:   10 * time.Minute, // sitemaps are large, but mustn't hang forever
+		},
+		log: internal.NewLogger("Sitemap"),
+	}
+}
+
+// Calls fn for every url of the sitemap, following sitemap indexes.
+// Sitemaps of an index that fail are logged and skipped.
+func (f *Fetcher) Walk(ctx context.Context, sitemapURL string, fn func(Entry) error) error {
+	return f.walk(ctx, sitemapURL, fn, make(map[string]bool), 0)
+}
+
+func (f *Fetcher) walk(ctx context.Context, sitemapURL string, fn func(Entry) error, visited map[string]bool, depth int) error {
+	if visited[sitemapURL] {
+		return nil
+	}
+	visited[sitemapURL] = true
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sitemap %s returned status %d", sitemapURL, res.StatusCode)
+	}
+	f.log.Info("reading sitemap", slog.String("url", sitemapURL))
+
+	// sitemaps of an index are read once the index is read, so only one response is open at a time
+	var children []string
+	err = Parse(res.Body, fn, func(e Entry) error {
+		if depth+1 <= f.opts.MaxDepth {
+			children = append(children, e.Loc)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	for _, child := range children {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := f.walk(ctx, child, fn, visited, depth+1); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			f.log.Error("failed to read sitemap", internal.ErrAttr(err), slog.String("url", child))
+		}
+	}
+	return nil
+}
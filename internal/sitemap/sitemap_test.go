@@ -0,0 +1,118 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://www.amazon.com/dp/B00NHQFA1I</loc>
+    <lastmod>2025-03-01T10:20:30+00:00</lastmod>
+  </url>
+  <url>
+    <loc> https://www.amazon.com/b?node=165793011 </loc>
+    <lastmod>2025-02-14</lastmod>
+  </url>
+  <url>
+    <loc>https://www.amazon.com/gp/help/customer/display.html</loc>
+  </url>
+  <url><lastmod>2025-01-01</lastmod></url>
+</urlset>`
+
+const index = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://www.amazon.com/sitemap_1.xml.gz</loc>
+    <lastmod>2025-03-01</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>https://www.amazon.com/sitemap_2.xml.gz</loc>
+  </sitemap>
+</sitemapindex>`
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseURLSet(t *testing.T) {
+	expected := []Entry{
+		{Loc: "https://www.amazon.com/dp/B00NHQFA1I", LastMod: time.Date(2025, 3, 1, 10, 20, 30, 0, time.UTC)},
+		{Loc: "https://www.amazon.com/b?node=165793011", LastMod: time.Date(2025, 2, 14, 0, 0, 0, 0, time.UTC)},
+		{Loc: "https://www.amazon.com/gp/help/customer/display.html"},
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"plain", []byte(urlset)},
+		{"gzipped", gzipped(t, urlset)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []Entry
+			err := Parse(bytes.NewReader(test.input), func(e Entry) error {
+				entries = append(entries, e)
+				return nil
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(entries, expected, func(a, b Entry) bool {
+				return a.Loc == b.Loc && a.LastMod.Equal(b.LastMod)
+			}) {
+				t.Errorf("Parse() = %v; want %v", entries, expected)
+			}
+		})
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	var sitemaps []string
+	err := Parse(strings.NewReader(index), nil, func(e Entry) error {
+		sitemaps = append(sitemaps, e.Loc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://www.amazon.com/sitemap_1.xml.gz", "https://www.amazon.com/sitemap_2.xml.gz"}
+	if !slices.Equal(sitemaps, expected) {
+		t.Errorf("Parse() = %v; want %v", sitemaps, expected)
+	}
+}
+
+func TestParseStopsOnCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	err := Parse(strings.NewReader(urlset), func(e Entry) error {
+		count++
+		return stop
+	}, nil)
+	if !errors.Is(err, stop) || count != 1 {
+		t.Errorf("Parse() = %v after %d entries; want the callback error after 1", err, count)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	err := Parse(strings.NewReader("<urlset><url><loc>https://www.amazon.com/dp/B00NHQFA1I"), func(Entry) error { return nil }, nil)
+	if err == nil {
+		t.Error("expected error for truncated sitemap")
+	}
+}
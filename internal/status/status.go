@@ -0,0 +1,87 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/jonashiltl/amazon-crawler/internal"
+)
+
+// A Provider returns a JSON serializable snapshot of some statistics.
+type Provider func() any
+
+// Serves the statistics of the registered providers as JSON, e.g. to be scraped by dashboards.
+// GET /status returns all providers, GET /status/{name} a single one.
+type Server struct {
+	srv       *http.Server
+	log       *slog.Logger
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+func NewServer(addr string) *Server {
+	s := &Server{
+		log:       internal.NewLogger("StatusServer"),
+		providers: make(map[string]Provider),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleAll)
+	mux.HandleFunc("GET /status/{name}", s.handleOne)
+	s.srv = &http.Server{Addr: addr, Handler: mux}
+	return s
+}
+
+func (s *Server) Handle(name string, provider Provider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[name] = provider
+}
+
+func (s *Server) Start() {
+	s.log.Info("serving status", slog.String("addr", s.srv.Addr))
+	go func() {
+		err := s.srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("status server stopped", internal.ErrAttr(err))
+		}
+	}()
+}
+
+func (s *Server) Close(ctx context.Context) {
+	s.srv.Shutdown(ctx)
+}
+
+func (s *Server) handleAll(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	all := make(map[string]any, len(s.providers))
+	for name, provider := range s.providers {
+		all[name] = provider()
+	}
+	s.mu.RUnlock()
+
+	writeJSON(w, all)
+}
+
+func (s *Server) handleOne(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	provider, ok := s.providers[r.PathValue("name")]
+	s.mu.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, provider())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import "fmt"
+
+// Decides in which order queued urls are leased.
+type Frontier string
+
+const (
+	FIFO         Frontier = "fifo"          // in the order they were queued, walks the primary key
+	BFS          Frontier = "bfs"           // shallow urls first, in the order they were found
+	DFS          Frontier = "dfs"           // deep urls first, the most recently found first
+	ProductFirst Frontier = "product_first" // product pages before listings, otherwise breadth first
+	Random       Frontier = "random"        // uniform sample of the queue
+	BestFirst    Frontier = "best_first"    // highest priority first, ties broken breadth first
+)
+
+// Returns the ORDER BY clause of the frontier, used to select the next url.
+// Every order is backed by an index of the leasable urls, see ensureSchema,
+// so leasing doesn't sort the whole queue.
+func (f Frontier) orderBy() (string, error) {
+	switch f {
+	case FIFO, "":
+		return "id", nil
+	case BFS:
+		return "depth, id", nil
+	case DFS:
+		return "depth DESC, id DESC", nil
+	case ProductFirst:
+		// products are keyed by their host and asin, see canonical.Key
+		return "(canonical_key LIKE 'asin:%') DESC, depth, id", nil
+	case Random:
+		// a random key drawn when the url is queued, unlike random() it can be indexed
+		return "sample_key", nil
+	case BestFirst:
+		return "priority DESC, depth, id", nil
+	default:
+		return "", fmt.Errorf("unknown frontier %q, expected fifo, bfs, dfs, product_first, random or best_first", f)
+	}
+}
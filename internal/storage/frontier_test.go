@@ -0,0 +1,31 @@
+package storage
+
+import "testing"
+
+func TestFrontierOrderBy(t *testing.T) {
+	tests := []struct {
+		frontier Frontier
+		expected string
+		wantErr  bool
+	}{
+		{"", "id", false},
+		{FIFO, "id", false},
+		{BFS, "depth, id", false},
+		{DFS, "depth DESC, id DESC", false},
+		{ProductFirst, "(canonical_key LIKE 'asin:%') DESC, depth, id", false},
+		{Random, "sample_key", false},
+		{BestFirst, "priority DESC, depth, id", false},
+		{"priority", "", true},
+	}
+
+	for _, test := range tests {
+		result, err := test.frontier.orderBy()
+		if (err != nil) != test.wantErr {
+			t.Errorf("Frontier(%q).orderBy() error = %v; wantErr %v", test.frontier, err, test.wantErr)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Frontier(%q).orderBy() = %q; want %q", test.frontier, result, test.expected)
+		}
+	}
+}
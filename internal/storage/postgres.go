@@ -5,25 +5,47 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/canonical"
 )
 
 type PGOptions struct {
-	DatabaseURL string
+	DatabaseURL     string
+	InstanceID      string        // identifies this crawler instance as the owner of leased urls
+	LeaseDuration   time.Duration // how long a leased url is owned before it can be reclaimed
+	InstanceTimeout time.Duration // instances without a heartbeat for this long are considered dead
+	Frontier        Frontier      // order in which queued urls are leased, defaults to FIFO
 }
 
 type pgStorage struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	PGOptions
+	pool    *pgxpool.Pool
+	log     *slog.Logger
+	orderBy string // ORDER BY clause of the frontier
 }
 
 func NewPGStorage(opts PGOptions) (Storage, error) {
 	if opts.DatabaseURL == "" {
 		return nil, errors.New("missing POSTGRES_URL config variable")
 	}
+	if opts.InstanceID == "" {
+		return nil, errors.New("missing instance id")
+	}
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = 2 * time.Minute
+	}
+	if opts.InstanceTimeout <= 0 {
+		opts.InstanceTimeout = time.Minute
+	}
+	orderBy, err := opts.Frontier.orderBy()
+	if err != nil {
+		return nil, err
+	}
 
 	ctx := context.Background()
 	dbpool, err := pgxpool.New(ctx, opts.DatabaseURL)
@@ -32,14 +54,21 @@ func NewPGStorage(opts PGOptions) (Storage, error) {
 	}
 
 	s := &pgStorage{
-		pool: dbpool,
-		log:  internal.NewLogger("PGStorage"),
+		PGOptions: opts,
+		pool:      dbpool,
+		log:       internal.NewLogger("PGStorage").With(slog.String("instance", opts.InstanceID)),
+		orderBy:   orderBy,
 	}
 	err = s.ensureSchema(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	err = s.Heartbeat(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
@@ -47,59 +76,87 @@ func (p *pgStorage) Close() {
 	p.pool.Close()
 }
 
-func (p *pgStorage) AddURLs(ctx context.Context, urls []string) error {
-	if len(urls) == 0 {
-		return nil
+func (p *pgStorage) AddURLs(ctx context.Context, links []Link) ([]Link, error) {
+	if len(links) == 0 {
+		return nil, nil
 	}
 
-	p.log.Debug("batch inserting urls", slog.Int("len", len(urls)))
+	p.log.Debug("batch inserting urls", slog.Int("len", len(links)))
 	batch := &pgx.Batch{}
-	for _, url := range urls {
+	batched := make([]Link, 0, len(links))
+	for _, link := range links {
+		url, err := canonical.URL(link.URL)
+		if err != nil {
+			p.log.Debug("skipping invalid url", slog.String("url", link.URL), internal.ErrAttr(err))
+			continue
+		}
+		key, _ := canonical.Key(url)
+		// a page is only queued once, however its url is written.
+		// The lineage of the first discovery is kept, which is the shortest path in breadth first order.
 		batch.Queue(`
-            INSERT INTO url_queue (url, status)
-            VALUES ($1, 'queued')
-            ON CONFLICT (url) DO NOTHING
-        `, url)
+            INSERT INTO url_queue (url, canonical_key, status, parent_url, seed_url, depth, link_context, priority, lastmod, tag)
+            VALUES ($1, $2, 'queued', NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''))
+            ON CONFLICT DO NOTHING
+        `, url, key, link.Parent, link.Seed, link.Depth, string(link.Context), link.Priority, nullTime(link.LastMod), link.Tag)
+		link.URL = url
+		batched = append(batched, link)
 	}
 
 	br := p.pool.SendBatch(ctx, batch)
 	defer br.Close()
 
-	for range urls {
-		if _, err := br.Exec(); err != nil {
-			return fmt.Errorf("batch insert error: %w", err)
+	var added []Link
+	for _, link := range batched {
+		tag, err := br.Exec()
+		if err != nil {
+			return added, fmt.Errorf("batch insert error: %w", err)
+		}
+		if tag.RowsAffected() > 0 {
+			added = append(added, link)
 		}
 	}
 
-	return nil
+	return added, nil
 }
 
-func (p *pgStorage) GetNextURL(ctx context.Context) (QueuedURL, error) {
+func (p *pgStorage) GetNextURL(ctx context.Context, identity string) (QueuedURL, error) {
 	var q QueuedURL
-	// selects the next url and marks it "processing" in a single query
-	// FOR UPDATE SKIP LOCKED ensures only one process retrieves and locks urls
-	row := p.pool.QueryRow(ctx, `
+	// selects the next url and leases it to this instance in a single query
+	// FOR UPDATE SKIP LOCKED ensures only one process retrieves and locks urls.
+	// Urls in "processing" are reclaimed once their lease expired or their owner stopped sending heartbeats.
+	row := p.pool.QueryRow(ctx, fmt.Sprintf(`
 		WITH next_url AS (
 			SELECT url
 			FROM url_queue
 			WHERE 
 				status = 'queued'
-				OR (status = 'processing' AND started_at < now() - INTERVAL '5 minute')
 				OR (
-        			status = 'failed'
-        			AND NOW() >= failed_at + INTERVAL '5 minutes' * POWER(2, GREATEST(retry_count - 1, 0))
-        			AND retry_count < 3
+					status = 'processing'
+					AND (
+						lease_expires_at IS NULL
+						OR lease_expires_at < NOW()
+						OR NOT EXISTS (
+							SELECT 1 FROM instances
+							WHERE instances.id = url_queue.instance_id
+							AND instances.heartbeat_at >= NOW() - $3::INTERVAL
+						)
+					)
+				)
+				OR (
+        			status IN ('failed', 'blocked')
+        			AND retry_at <= NOW()
+        			AND (avoid_identity IS NULL OR avoid_identity <> $4)
     			)
-			ORDER BY id
+			ORDER BY %s
 			FOR UPDATE SKIP LOCKED
 			LIMIT 1
 		)
 		UPDATE url_queue
-		SET status = 'processing', started_at = now()
+		SET status = 'processing', started_at = NOW(), instance_id = $1, lease_expires_at = NOW() + $2::INTERVAL
 		FROM next_url
 		WHERE url_queue.url = next_url.url
-		RETURNING url_queue.url, url_queue.status
-	`)
+		RETURNING url_queue.url, url_queue.status, url_queue.retry_count, url_queue.depth, url_queue.seed_url, url_queue.tag
+	`, p.orderBy), p.InstanceID, p.LeaseDuration, p.InstanceTimeout, identity)
 	err := q.FromRow(row)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -111,30 +168,257 @@ func (p *pgStorage) GetNextURL(ctx context.Context) (QueuedURL, error) {
 	return q, nil
 }
 
+func (p *pgStorage) ExtendLease(ctx context.Context, url string) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE url_queue
+		SET lease_expires_at = NOW() + $1::INTERVAL
+		WHERE url = $2 AND status = 'processing' AND instance_id = $3
+	`, p.LeaseDuration, url, p.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to extend lease of %s: %w", url, err)
+	}
+	return nil
+}
+
+func (p *pgStorage) Heartbeat(ctx context.Context) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO instances (id, heartbeat_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (id) DO UPDATE SET heartbeat_at = NOW()
+	`, p.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Only the instance holding the lease may complete an url,
+// otherwise a reclaimed url could be marked by two instances.
 func (p *pgStorage) MarkDone(ctx context.Context, url string) error {
 	_, err := p.pool.Exec(ctx, `
 		UPDATE url_queue
-		SET status = 'done', done_at = NOW()
-		WHERE url = $1
-	`, url)
+		SET status = 'done', done_at = NOW(), lease_expires_at = NULL
+		WHERE url = $1 AND instance_id = $2
+	`, url, p.InstanceID)
 	if err != nil {
 		return fmt.Errorf("failed to mark %s as done: %w", url, err)
 	}
 	return nil
 }
 
-func (p *pgStorage) MarkFailed(ctx context.Context, url string, msg string) error {
+func (p *pgStorage) MarkFailed(ctx context.Context, url string, f Failure) error {
+	return p.markUnsuccessful(ctx, url, "failed", f)
+}
+
+func (p *pgStorage) MarkBlocked(ctx context.Context, url string, f Failure) error {
+	return p.markUnsuccessful(ctx, url, "blocked", f)
+}
+
+func (p *pgStorage) markUnsuccessful(ctx context.Context, url string, status string, f Failure) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE url_queue
+		SET
+			status = $7,
+			failed_at = NOW(),
+			retry_count = retry_count + 1,
+			reason = $1,
+			error_class = $2,
+			retry_at = $3,
+			avoid_identity = NULLIF($4, ''),
+			lease_expires_at = NULL
+		WHERE url = $5 AND instance_id = $6
+	`, f.Reason, f.Class, f.RetryAt, f.AvoidIdentity, url, p.InstanceID, status)
+	if err != nil {
+		return fmt.Errorf("failed to mark %s as %s: %w", url, status, err)
+	}
+	return nil
+}
+
+func (p *pgStorage) MarkGone(ctx context.Context, url string, reason string) error {
 	_, err := p.pool.Exec(ctx, `
 		UPDATE url_queue
-		SET status = 'failed', failed_at = NOW(), retry_count = retry_count + 1, reason = $1
-		WHERE url = $2
-	`, msg, url)
+		SET
+			status = 'gone',
+			failed_at = NOW(),
+			reason = $1,
+			error_class = 'gone',
+			retry_at = NULL,
+			lease_expires_at = NULL
+		WHERE url = $2 AND instance_id = $3
+	`, reason, url, p.InstanceID)
 	if err != nil {
-		return fmt.Errorf("failed to mark %s as failed: %w", url, err)
+		return fmt.Errorf("failed to mark %s as gone: %w", url, err)
 	}
 	return nil
 }
 
+func (p *pgStorage) SaveIdentity(ctx context.Context, id Identity) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO identities (id, instance_id, user_agent, proxy, started_at, retired_at, requests, blocks)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE
+		SET retired_at = EXCLUDED.retired_at, requests = EXCLUDED.requests, blocks = EXCLUDED.blocks
+	`, id.ID, p.InstanceID, id.UserAgent, id.Proxy, id.StartedAt, id.RetiredAt, id.Requests, id.Blocks)
+	if err != nil {
+		return fmt.Errorf("failed to save identity %s: %w", id.ID, err)
+	}
+	return nil
+}
+
+func (p *pgStorage) ReleaseURL(ctx context.Context, url string) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE url_queue
+		SET status = 'queued', started_at = NULL, instance_id = NULL, lease_expires_at = NULL
+		WHERE url = $1 AND status = 'processing' AND instance_id = $2
+	`, url, p.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to release %s: %w", url, err)
+	}
+	return nil
+}
+
+func (p *pgStorage) ReleaseURLs(ctx context.Context) (int, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE url_queue
+		SET status = 'queued', started_at = NULL, instance_id = NULL, lease_expires_at = NULL
+		WHERE status = 'processing' AND instance_id = $1
+	`, p.InstanceID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release urls: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (p *pgStorage) RecordVisit(ctx context.Context, url string, fingerprint string) (Visits, error) {
+	var v Visits
+	// all expressions of SET see the previous fingerprint
+	err := p.pool.QueryRow(ctx, `
+		UPDATE url_queue
+		SET
+			changes = changes + CASE WHEN fingerprint IS NOT NULL AND fingerprint <> $1 THEN 1 ELSE 0 END,
+			visits = visits + 1,
+			fingerprint = $1,
+			first_visit_at = COALESCE(first_visit_at, NOW()),
+			last_visit_at = NOW()
+		WHERE url = $2 AND instance_id = $3
+		RETURNING visits, changes, first_visit_at, last_visit_at
+	`, fingerprint, url, p.InstanceID).Scan(&v.Count, &v.Changes, &v.First, &v.Last)
+	if err != nil {
+		return Visits{}, fmt.Errorf("failed to record visit of %s: %w", url, err)
+	}
+	return v, nil
+}
+
+func (p *pgStorage) ScheduleRevisit(ctx context.Context, url string, at time.Time) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE url_queue SET next_visit_at = $1 WHERE url = $2 AND instance_id = $3
+	`, at, url, p.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule revisit of %s: %w", url, err)
+	}
+	return nil
+}
+
+func (p *pgStorage) RequeueDue(ctx context.Context) (int, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE url_queue
+		SET status = 'queued', retry_count = 0, started_at = NULL, instance_id = NULL, next_visit_at = NULL
+		WHERE status = 'done' AND next_visit_at <= NOW()
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue due urls: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (p *pgStorage) SavePartition(ctx context.Context, part Partition) (bool, error) {
+	// the sub-searches of a search are only planned once, the previous split is kept
+	var split bool
+	// the CTE sees the partition as it was before the insert
+	err := p.pool.QueryRow(ctx, `
+		WITH previous AS (
+			SELECT split_by FROM search_partitions WHERE url = $1
+		)
+		INSERT INTO search_partitions (url, results, capped, split_by, children, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NOW())
+		ON CONFLICT (url) DO UPDATE SET
+			results = EXCLUDED.results,
+			capped = EXCLUDED.capped,
+			split_by = COALESCE(search_partitions.split_by, EXCLUDED.split_by),
+			children = CASE WHEN search_partitions.split_by IS NULL THEN EXCLUDED.children ELSE search_partitions.children END,
+			updated_at = NOW()
+		RETURNING (SELECT split_by FROM previous) IS NULL
+	`, part.URL, part.Results, part.Capped, part.SplitBy, part.Children).Scan(&split)
+	if err != nil {
+		return false, fmt.Errorf("failed to save partition of %s: %w", part.URL, err)
+	}
+	return split, nil
+}
+
+func (p *pgStorage) AddBudgetUsage(ctx context.Context, run string, usage map[string]int64) (map[string]int64, error) {
+	totals := make(map[string]int64, len(usage))
+	if len(usage) == 0 {
+		return totals, nil
+	}
+
+	batch := &pgx.Batch{}
+	for name, delta := range usage {
+		batch.Queue(`
+			INSERT INTO budget_usage (run, name, value, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (run, name) DO UPDATE SET value = budget_usage.value + EXCLUDED.value, updated_at = NOW()
+			RETURNING name, value
+		`, run, name, delta)
+	}
+
+	br := p.pool.SendBatch(ctx, batch)
+	defer br.Close()
+
+	for range batch.Len() {
+		var name string
+		var value int64
+		if err := br.QueryRow().Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to add budget usage: %w", err)
+		}
+		totals[name] = value
+	}
+	return totals, nil
+}
+
+func (p *pgStorage) BudgetUsage(ctx context.Context, run string) (map[string]int64, error) {
+	rows, err := p.pool.Query(ctx, `SELECT name, value FROM budget_usage WHERE run = $1`, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to get budget usage: %w", err)
+		}
+		usage[name] = value
+	}
+	return usage, rows.Err()
+}
+
+func (p *pgStorage) StartBudgetRun(ctx context.Context, run string) (time.Time, error) {
+	var startedAt time.Time
+	// the no-op update makes RETURNING yield the row another instance inserted
+	err := p.pool.QueryRow(ctx, `
+		INSERT INTO budget_runs (run, started_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (run) DO UPDATE SET run = EXCLUDED.run
+		RETURNING started_at
+	`, run).Scan(&startedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to start budget run %s: %w", run, err)
+	}
+	return startedAt, nil
+}
+
 func (p *pgStorage) QueueSize(ctx context.Context) (int, error) {
 	var count int
 	err := p.pool.QueryRow(ctx, `
@@ -151,7 +435,7 @@ func (p *pgStorage) ensureSchema(ctx context.Context) error {
     CREATE TABLE IF NOT EXISTS url_queue (
         id SERIAL PRIMARY KEY,
         url TEXT UNIQUE NOT NULL,
-        status TEXT NOT NULL CHECK (status IN ('queued', 'processing', 'done', 'failed')),
+        status TEXT NOT NULL CHECK (status IN ('queued', 'processing', 'done', 'failed', 'blocked', 'gone')),
 		reason TEXT,
         queued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 		started_at TIMESTAMPTZ,
@@ -161,9 +445,190 @@ func (p *pgStorage) ensureSchema(ctx context.Context) error {
     );
 
     CREATE INDEX IF NOT EXISTS idx_url_queue_status ON url_queue (status, started_at);
+
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS instance_id TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS error_class TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS retry_at TIMESTAMPTZ;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS avoid_identity TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS canonical_key TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS parent_url TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS seed_url TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS link_context TEXT;
+
+    CREATE INDEX IF NOT EXISTS idx_url_queue_seed_depth ON url_queue (seed_url, depth);
+
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS sample_key DOUBLE PRECISION NOT NULL DEFAULT random();
+
+    -- one index per frontier order, limited to the statuses GetNextURL leases.
+    -- dfs scans the bfs index backwards.
+    DROP INDEX IF EXISTS idx_url_queue_priority;
+    CREATE INDEX IF NOT EXISTS idx_url_queue_bfs ON url_queue (depth, id)
+        WHERE status IN ('queued', 'processing', 'failed', 'blocked');
+    CREATE INDEX IF NOT EXISTS idx_url_queue_product_first ON url_queue ((canonical_key LIKE 'asin:%') DESC, depth, id)
+        WHERE status IN ('queued', 'processing', 'failed', 'blocked');
+    CREATE INDEX IF NOT EXISTS idx_url_queue_random ON url_queue (sample_key)
+        WHERE status IN ('queued', 'processing', 'failed', 'blocked');
+    CREATE INDEX IF NOT EXISTS idx_url_queue_best_first ON url_queue (priority DESC, depth, id)
+        WHERE status IN ('queued', 'processing', 'failed', 'blocked');
+
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS lastmod TIMESTAMPTZ;
+
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS tag TEXT;
+    CREATE INDEX IF NOT EXISTS idx_url_queue_tag ON url_queue (tag) WHERE tag IS NOT NULL;
+
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS fingerprint TEXT;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS visits INT NOT NULL DEFAULT 0;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS changes INT NOT NULL DEFAULT 0;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS first_visit_at TIMESTAMPTZ;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS last_visit_at TIMESTAMPTZ;
+    ALTER TABLE url_queue ADD COLUMN IF NOT EXISTS next_visit_at TIMESTAMPTZ;
+    CREATE INDEX IF NOT EXISTS idx_url_queue_next_visit ON url_queue (next_visit_at) WHERE status = 'done';
+
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_url_queue_canonical_key ON url_queue (canonical_key);
+
+    CREATE INDEX IF NOT EXISTS idx_url_queue_error_class ON url_queue (error_class);
+
+    -- failures recorded before error classes existed keep their old retry schedule
+    UPDATE url_queue
+    SET retry_at = failed_at + INTERVAL '5 minutes' * POWER(2, GREATEST(retry_count - 1, 0))
+    WHERE status = 'failed' AND error_class IS NULL AND retry_at IS NULL AND retry_count < 3;
+
+    ALTER TABLE url_queue DROP CONSTRAINT IF EXISTS url_queue_status_check;
+    ALTER TABLE url_queue ADD CONSTRAINT url_queue_status_check
+        CHECK (status IN ('queued', 'processing', 'done', 'failed', 'blocked', 'gone'));
+
+    CREATE TABLE IF NOT EXISTS identities (
+        id TEXT PRIMARY KEY,
+        instance_id TEXT NOT NULL,
+        user_agent TEXT NOT NULL,
+        proxy TEXT,
+        started_at TIMESTAMPTZ NOT NULL,
+        retired_at TIMESTAMPTZ,
+        requests INT NOT NULL DEFAULT 0,
+        blocks INT NOT NULL DEFAULT 0
+    );
+
+    CREATE TABLE IF NOT EXISTS instances (
+        id TEXT PRIMARY KEY,
+        started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS search_partitions (
+        url TEXT PRIMARY KEY,
+        results INT NOT NULL,
+        capped BOOLEAN NOT NULL,
+        split_by TEXT,
+        children TEXT[] NOT NULL DEFAULT '{}',
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS budget_usage (
+        run TEXT NOT NULL,
+        name TEXT NOT NULL,
+        value BIGINT NOT NULL DEFAULT 0,
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (run, name)
+    );
+
+    CREATE TABLE IF NOT EXISTS budget_runs (
+        run TEXT PRIMARY KEY,
+        started_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
     `
 	if _, err := p.pool.Exec(ctx, migration); err != nil {
 		return fmt.Errorf("migration failed: %w", err)
 	}
-	return nil
+	return p.backfillCanonicalKeys(ctx)
+}
+
+// Sets the canonical key of urls queued before keys existed, or before products were keyed by their marketplace.
+// Of urls sharing a key, a done one keeps it and the others are deleted.
+func (p *pgStorage) backfillCanonicalKeys(ctx context.Context) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// instances starting at the same time mustn't assign the same key twice
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('url_queue_canonical_key'))`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, url FROM url_queue
+		-- products were keyed without their marketplace before
+		WHERE canonical_key IS NULL OR canonical_key ~ '^asin:[^:]*$'
+		ORDER BY (status = 'done') DESC, id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to select urls without canonical key: %w", err)
+	}
+	type row struct {
+		id  int
+		url string
+	}
+	legacy, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (row, error) {
+		var lr row
+		err := r.Scan(&lr.id, &lr.url)
+		return lr, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to select urls without canonical key: %w", err)
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+	p.log.Info(fmt.Sprintf("setting canonical keys of %d urls", len(legacy)))
+
+	var duplicates []int
+	for chunk := range slices.Chunk(legacy, 1000) {
+		batch := &pgx.Batch{}
+		for _, lr := range chunk {
+			key, err := canonical.Key(lr.url)
+			if err != nil {
+				key = lr.url // unique as well
+			}
+			batch.Queue(`
+				UPDATE url_queue SET canonical_key = $1
+				WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM url_queue WHERE canonical_key = $1)
+			`, key, lr.id)
+		}
+
+		br := tx.SendBatch(ctx, batch)
+		for _, lr := range chunk {
+			tag, err := br.Exec()
+			if err != nil {
+				br.Close()
+				return fmt.Errorf("failed to set canonical key: %w", err)
+			}
+			if tag.RowsAffected() == 0 {
+				duplicates = append(duplicates, lr.id)
+			}
+		}
+		if err := br.Close(); err != nil {
+			return err
+		}
+	}
+
+	// urls still processing are assigned a key once they're done, on the next start
+	tag, err := tx.Exec(ctx, `DELETE FROM url_queue WHERE id = ANY($1) AND status <> 'processing'`, duplicates)
+	if err != nil {
+		return fmt.Errorf("failed to delete duplicate urls: %w", err)
+	}
+	p.log.Info(fmt.Sprintf("deleted %d duplicate urls", tag.RowsAffected()))
+
+	return tx.Commit(ctx)
+}
+
+// Stores zero times as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
@@ -2,24 +2,74 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
 
 // A storage layer manages the set of URLs to be scraped.
 type Storage interface {
-	// Add the URLs to the queue.
-	// The implementation must handle deduplication of already queued urls
-	AddURLs(ctx context.Context, url []string) error
+	// Add the URLs of the links to the queue, together with how they were discovered.
+	// The implementation must handle deduplication of already queued urls.
+	// Returns the links that weren't queued before.
+	AddURLs(ctx context.Context, links []Link) ([]Link, error)
 
-	// Retrieves the next URL and marks it as "Processing".
-	GetNextURL(ctx context.Context) (QueuedURL, error)
+	// Retrieves the next URL, marks it as "Processing" and leases it to this instance.
+	// URLs whose lease expired or whose owning instance stopped sending heartbeats are reclaimed.
+	// URLs that must be retried on a different identity aren't returned to the same identity.
+	GetNextURL(ctx context.Context, identity string) (QueuedURL, error)
+
+	// Extends the lease of an URL still being processed by this instance.
+	ExtendLease(ctx context.Context, url string) error
+
+	// Records that this instance is still alive.
+	Heartbeat(ctx context.Context) error
 
 	// Marks the URL as done.
 	MarkDone(ctx context.Context, url string) error
 
-	// Marks the URL as failed.
-	MarkFailed(ctx context.Context, url string, msg string) error
+	// Marks the URL as failed and schedules its retry.
+	MarkFailed(ctx context.Context, url string, f Failure) error
+
+	// Marks the URL as blocked by the site and schedules its retry.
+	MarkBlocked(ctx context.Context, url string, f Failure) error
+
+	// Creates or updates the request and block statistics of a crawler identity.
+	SaveIdentity(ctx context.Context, id Identity) error
+
+	// Marks the URL as permanently gone, it's never retried.
+	MarkGone(ctx context.Context, url string, reason string) error
+
+	// Puts an URL leased by this instance back into the queue, without counting it as a retry.
+	ReleaseURL(ctx context.Context, url string) error
+
+	// Puts the URLs still leased by this instance back into the queue.
+	// Returns the number of released URLs.
+	ReleaseURLs(ctx context.Context) (int, error)
+
+	// Records a visit of the URL leased by this instance and whether its content changed since the previous visit,
+	// by comparing the fingerprint of the content. Returns the visits of the URL so far.
+	RecordVisit(ctx context.Context, url string, fingerprint string) (Visits, error)
+
+	// Schedules the next visit of the URL, it's queued again once it's done and the time has come.
+	ScheduleRevisit(ctx context.Context, url string, at time.Time) error
+
+	// Queues the done URLs whose next visit is due.
+	// Returns the number of queued URLs.
+	RequeueDue(ctx context.Context) (int, error)
+
+	// Records how the search was partitioned. Returns false if the search was already split before.
+	SavePartition(ctx context.Context, p Partition) (bool, error)
+
+	// Adds to the budget counters of the run shared by all instances and returns their new totals.
+	AddBudgetUsage(ctx context.Context, run string, usage map[string]int64) (map[string]int64, error)
+
+	// Returns all budget counters of the run.
+	BudgetUsage(ctx context.Context, run string) (map[string]int64, error)
+
+	// Records the start of the run, unless another instance started it before.
+	// Returns when the run started.
+	StartBudgetRun(ctx context.Context, run string) (time.Time, error)
 
 	// Returns the number of URLs waiting in the queue.
 	QueueSize(ctx context.Context) (int, error)
@@ -34,20 +84,96 @@ const (
 	Processing
 	Done
 	Failed
+	Blocked
+	Gone
 )
 
 type QueuedURL struct {
-	URL    string
-	Status Status
+	URL        string
+	Status     Status
+	RetryCount int    // how often processing the URL failed before
+	Depth      int    // number of links followed from the seed, 0 for seeds
+	Seed       string // the seed url the URL descends from, empty for seeds and urls queued before lineage was tracked
+	Tag        string // the keyword the URL was found through, empty if it wasn't
+}
+
+// The visit history of an URL.
+type Visits struct {
+	Count   int // including the current visit
+	Changes int // visits whose content differed from the previous visit
+	First   time.Time
+	Last    time.Time
+}
+
+// Where on the parent page a link was found.
+type LinkContext string
+
+const (
+	LinkSeed           LinkContext = "seed"
+	LinkPagination     LinkContext = "pagination"
+	LinkSearch         LinkContext = "search"
+	LinkCategory       LinkContext = "category"
+	LinkProduct        LinkContext = "product"
+	LinkBoughtTogether LinkContext = "bought_together"
+	LinkFacet          LinkContext = "facet" // a sub-search of a search with too many results
+	LinkSitemap        LinkContext = "sitemap"
+)
+
+// A search and its sub-searches, if Amazon shows only a part of its results.
+type Partition struct {
+	URL      string
+	Results  int  // the number of results shown by Amazon
+	Capped   bool // not all results fit on the shown pages
+	SplitBy  string
+	Children []string
+}
+
+// A discovered url and its lineage.
+type Link struct {
+	URL      string
+	Parent   string
+	Seed     string
+	Depth    int
+	Context  LinkContext
+	Priority int       // higher is leased earlier by the best first frontier
+	LastMod  time.Time // when the page last changed according to a sitemap, zero if unknown
+	Tag      string    // the keyword the link was found through
+}
+
+// Describes why processing an URL failed and when it is retried.
+type Failure struct {
+	Class         string // the error class, persisted in its own column
+	Reason        string
+	RetryAt       *time.Time // nil if the URL must not be retried
+	AvoidIdentity string     // if set, the retry must happen on another identity
+}
+
+// The User-Agent and proxy a crawler presents to the site, with the number of requests it
+// made and how often it got blocked.
+type Identity struct {
+	ID        string
+	UserAgent string
+	Proxy     string
+	StartedAt time.Time
+	RetiredAt *time.Time
+	Requests  int
+	Blocks    int
 }
 
 func (q *QueuedURL) FromRow(row pgx.Row) error {
 	var statusStr string
-	err := row.Scan(&q.URL, &statusStr)
+	var seed, tag *string
+	err := row.Scan(&q.URL, &statusStr, &q.RetryCount, &q.Depth, &seed, &tag)
 	if err != nil {
 		return err
 	}
 	q.Status = statusFromString(statusStr)
+	if seed != nil {
+		q.Seed = *seed
+	}
+	if tag != nil {
+		q.Tag = *tag
+	}
 	return nil
 }
 
@@ -61,6 +187,10 @@ func statusFromString(s string) Status {
 		return Done
 	case "failed":
 		return Failed
+	case "blocked":
+		return Blocked
+	case "gone":
+		return Gone
 	default:
 		return Queued
 	}
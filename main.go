@@ -2,17 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/jonashiltl/amazon-crawler/internal"
+	"github.com/jonashiltl/amazon-crawler/internal/breaker"
+	"github.com/jonashiltl/amazon-crawler/internal/budget"
+	"github.com/jonashiltl/amazon-crawler/internal/camoufox"
 	"github.com/jonashiltl/amazon-crawler/internal/config"
 	"github.com/jonashiltl/amazon-crawler/internal/consumer"
 	"github.com/jonashiltl/amazon-crawler/internal/crawler"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/facet"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/intercept"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/middleware"
+	"github.com/jonashiltl/amazon-crawler/internal/crawler/trap"
+	"github.com/jonashiltl/amazon-crawler/internal/crawlerr"
+	"github.com/jonashiltl/amazon-crawler/internal/proxy"
+	"github.com/jonashiltl/amazon-crawler/internal/recrawl"
+	"github.com/jonashiltl/amazon-crawler/internal/seed"
+	"github.com/jonashiltl/amazon-crawler/internal/status"
 	"github.com/jonashiltl/amazon-crawler/internal/storage"
 )
 
@@ -35,23 +49,149 @@ func main() {
 	}
 
 	storage, err := storage.NewPGStorage(storage.PGOptions{
-		DatabaseURL: cfg.PostgresURL,
+		DatabaseURL:     cfg.PostgresURL,
+		InstanceID:      cfg.InstanceID,
+		LeaseDuration:   cfg.LeaseDuration,
+		InstanceTimeout: cfg.InstanceTimeout,
+		Frontier:        storage.Frontier(cfg.Frontier),
 	})
 	if err != nil {
 		slog.Error("failed to create postgres storage", internal.ErrAttr(err))
 		os.Exit(1)
 	}
 
+	retryPolicies, err := crawlerr.ParsePolicies(cfg.RetryPolicies)
+	if err != nil {
+		slog.Error("failed to parse retry policies", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+
+	proxies, err := createProxyPool(&cfg)
+	if err != nil {
+		slog.Error("failed to create proxy pool", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+	proxies.StartHealthChecks(ctx)
+
+	var statusServer *status.Server
+	if cfg.StatusAddr != "" {
+		statusServer = status.NewServer(cfg.StatusAddr)
+		statusServer.Handle("proxies", func() any { return proxies.Stats() })
+		statusServer.Start()
+	}
+
+	var mwConfig middleware.Config
+	if cfg.MiddlewareFile != "" {
+		mwConfig, err = middleware.LoadConfig(cfg.MiddlewareFile)
+		if err != nil {
+			slog.Error("invalid request middlewares", internal.ErrAttr(err))
+			os.Exit(1)
+		}
+	}
+	requestMiddlewares, err := mwConfig.RequestMiddlewares()
+	if err != nil {
+		slog.Error("invalid request middlewares", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+
+	interceptor, err := createInterceptor(&cfg)
+	if err != nil {
+		slog.Error("invalid interception rules", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+	if statusServer != nil {
+		statusServer.Handle("interception", func() any { return interceptor.Stats() })
+	}
+
+	recrawler, err := createRecrawler(&cfg)
+	if err != nil {
+		slog.Error("invalid recrawl options", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+
+	crawlBudget, err := createBudget(&cfg)
+	if err != nil {
+		slog.Error("invalid budget", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+	if crawlBudget != nil && statusServer != nil {
+		statusServer.Handle("budget", func() any { return crawlBudget.Stats() })
+	}
+
+	facets, err := createFacetPlanner(&cfg)
+	if err != nil {
+		slog.Error("invalid facet expansion options", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+
+	var traps *trap.Detector
+	if cfg.TrapDetection {
+		traps = trap.New(trap.Options{
+			MaxURLs:      cfg.TrapMaxURLs,
+			MinPages:     cfg.TrapMinPages,
+			MaxTemplates: cfg.TrapMaxTemplates,
+			IdleTimeout:  cfg.TrapIdleTimeout,
+		})
+		if statusServer != nil {
+			statusServer.Handle("traps", func() any { return traps.Report() })
+		}
+	}
+
+	var keywords []seed.Keyword
+	if cfg.KeywordFile != "" {
+		keywords, err = seed.LoadKeywords(cfg.KeywordFile)
+		if err != nil {
+			slog.Error("invalid keywords", internal.ErrAttr(err))
+			os.Exit(1)
+		}
+	}
+
+	launcher, err := createLauncher(&cfg)
+	if err != nil {
+		slog.Error("invalid browser options", internal.ErrAttr(err))
+		os.Exit(1)
+	}
+
 	crawl, err := crawler.NewCrawler(ctx, crawler.Options{
 		Consumer:            consumer,
 		Storage:             storage,
 		SeedURLs:            cfg.SeedURLs,
 		PollInterval:        cfg.PollInterval,
-		Proxy:               cfg.Proxy,
-		ProxyPW:             cfg.ProxyPW,
-		ProxyUser:           cfg.ProxyUser,
+		Proxies:             proxies,
 		PlaywrightDriverDir: cfg.PlaywrightDriverDir,
-		Cancel:              cancel,
+		LeaseDuration:       cfg.LeaseDuration,
+		RetryPolicies:       retryPolicies,
+		Breaker:             breakerOptions(&cfg),
+		BlockCoolDown:       cfg.BlockCoolDown,
+		RestartOnBlock:      cfg.RestartOnBlock,
+		Launcher:            launcher,
+		Sessions: crawler.SessionOptions{
+			Size:        cfg.SessionPoolSize,
+			MaxAge:      cfg.SessionMaxAge,
+			MaxRequests: cfg.SessionMaxRequests,
+			Dir:         cfg.SessionDir,
+		},
+		Locations:            cfg.Locations,
+		RequestMiddlewares:   requestMiddlewares,
+		Timezone:             mwConfig.Timezone,
+		Interceptor:          interceptor,
+		Tombstones:           cfg.Tombstones,
+		MaxDepth:             cfg.MaxDepth,
+		Recrawl:              recrawler,
+		RecrawlCheckInterval: cfg.RecrawlCheck,
+		Budget:               crawlBudget,
+		Facets:               facets,
+		Traps:                traps,
+		Sitemaps:             cfg.Sitemaps,
+		SitemapsFromRobots:   cfg.SitemapsFromRobots,
+		Keywords:             keywords,
+		Marketplaces:         cfg.Marketplaces,
+		MaxPagesPerBrowser:   cfg.MaxPagesPerBrowser,
+		MaxBrowserMemoryMB:   cfg.MaxBrowserMemoryMB,
+		MaxRestarts:          cfg.MaxRestarts,
+		RestartWindow:        cfg.RestartWindow,
+		HeartbeatInterval:    cfg.HeartbeatInterval,
+		Cancel:               cancel,
 	})
 	if err != nil {
 		slog.Error("failed to create crawler", internal.ErrAttr(err))
@@ -72,9 +212,16 @@ func main() {
 		}
 	}
 
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	crawl.Drain(drainCtx)
+	cancelDrain()
+
 	crawl.Close()
 	consumer.Close()
 	storage.Close()
+	if statusServer != nil {
+		statusServer.Close(context.Background())
+	}
 }
 
 func createConsumer(cfg *config.Config) (consumer.Consumer, error) {
@@ -91,6 +238,170 @@ func createConsumer(cfg *config.Config) (consumer.Consumer, error) {
 	return consumer.NewStdoutConsumer(), nil
 }
 
+// Combines the PROXY, PROXIES and PROXY_FILE variables into one pool.
+func createProxyPool(cfg *config.Config) (proxy.Pool, error) {
+	var proxies []string
+	if cfg.Proxy != "" {
+		proxies = append(proxies, cfg.Proxy)
+	}
+	proxies = append(proxies, cfg.Proxies...)
+	if cfg.ProxyFile != "" {
+		fromFile, err := proxy.LoadFile(cfg.ProxyFile)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, fromFile...)
+	}
+
+	pool, err := proxy.NewProxyManager(proxy.Options{
+		Proxies:  proxies,
+		Username: cfg.ProxyUser,
+		Password: cfg.ProxyPW,
+		Strategy: proxy.Strategy(cfg.ProxyStrategy),
+		HealthOptions: proxy.HealthOptions{
+			MaxFailures:    cfg.ProxyMaxFailures,
+			MinScore:       cfg.ProxyMinScore,
+			QuarantineBase: cfg.ProxyQuarantine,
+			QuarantineMax:  cfg.ProxyQuarantineMax,
+			HealthURL:      cfg.ProxyHealthURL,
+			HealthInterval: cfg.ProxyHealthInterval,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pool.Len() > 0 {
+		slog.Info(fmt.Sprintf("using %d proxies with %s strategy", pool.Len(), cfg.ProxyStrategy))
+	}
+	return pool, nil
+}
+
+func createInterceptor(cfg *config.Config) (*intercept.Interceptor, error) {
+	rules := intercept.DefaultRules()
+	if cfg.InterceptRulesFile != "" {
+		var err error
+		rules, err = intercept.LoadFile(cfg.InterceptRulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return intercept.New(rules)
+}
+
+// Returns nil if the crawl is unbounded.
+func createBudget(cfg *config.Config) (*budget.Tracker, error) {
+	limits := budget.Limits{
+		Products:      cfg.BudgetProducts,
+		Pages:         cfg.BudgetPages,
+		Bytes:         cfg.BudgetBytes,
+		Duration:      cfg.BudgetDuration,
+		CategoryQuota: cfg.CategoryQuota,
+		SeedQuota:     cfg.SeedQuota,
+		Action:        budget.Action(cfg.BudgetAction),
+		Run:           cfg.BudgetRun,
+	}
+	if limits == (budget.Limits{Action: limits.Action, Run: limits.Run}) {
+		return nil, nil
+	}
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	return budget.New(limits), nil
+}
+
+// Returns nil if facet expansion is disabled.
+func createFacetPlanner(cfg *config.Config) (*facet.Planner, error) {
+	if !cfg.FacetExpansion {
+		return nil, nil
+	}
+	dims, err := facet.ParseDimensions(cfg.FacetDimensions)
+	if err != nil {
+		return nil, err
+	}
+	opts := facet.DefaultOptions()
+	opts.Dimensions = dims
+	opts.MaxPages = cfg.FacetMaxPages
+	opts.MinPriceSpan = cfg.FacetMinPriceSpan
+	return facet.NewPlanner(opts)
+}
+
+// Returns nil if recrawling is disabled.
+func createRecrawler(cfg *config.Config) (*recrawl.Scheduler, error) {
+	if !cfg.Recrawl {
+		return nil, nil
+	}
+	categories, err := recrawl.ParseCategories(cfg.RecrawlCategories)
+	if err != nil {
+		return nil, err
+	}
+	return recrawl.NewScheduler(recrawl.Options{
+		MinInterval: cfg.RecrawlMinInterval,
+		MaxInterval: cfg.RecrawlMaxInterval,
+		Categories:  categories,
+	})
+}
+
+func createLauncher(cfg *config.Config) (crawler.BrowserLauncher, error) {
+	switch cfg.BrowserMode {
+	case "camoufox":
+		opts, err := camoufoxOptions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return crawler.NewCamoufoxLauncher(opts), nil
+	case "firefox", "chromium":
+		return crawler.NewPlaywrightLauncher(cfg.BrowserMode, cfg.BrowserHeadless)
+	case "remote":
+		return crawler.NewRemoteLauncher(cfg.BrowserRemoteEngine, cfg.BrowserWSEndpoint)
+	default:
+		return nil, fmt.Errorf("unknown browser mode %q, expected camoufox, firefox, chromium or remote", cfg.BrowserMode)
+	}
+}
+
+func camoufoxOptions(cfg *config.Config) (camoufox.LaunchOptions, error) {
+	opts := camoufox.DefaultLaunchOptions()
+	opts.Port = cfg.CamoufoxPort
+	opts.WSPath = cfg.CamoufoxWSPath
+	opts.Headless = cfg.CamoufoxHeadless
+	opts.OS = cfg.CamoufoxOS
+	opts.Locale = cfg.CamoufoxLocale
+	opts.ScreenWidth = cfg.CamoufoxScreenW
+	opts.ScreenHeight = cfg.CamoufoxScreenH
+	opts.BlockImages = cfg.CamoufoxBlockImages
+	opts.GeoIP = cfg.CamoufoxGeoIP
+
+	if cfg.CamoufoxConfig != "" {
+		var extra map[string]any
+		if err := json.Unmarshal([]byte(cfg.CamoufoxConfig), &extra); err != nil {
+			return opts, fmt.Errorf("CAMOUFOX_CONFIG is not a JSON object: %w", err)
+		}
+		maps.Copy(opts.Config, extra)
+	}
+
+	// the port is only known at launch if it is picked automatically
+	validate := opts
+	if validate.Port == 0 {
+		validate.Port = 1
+	}
+	return opts, validate.Validate()
+}
+
+func breakerOptions(cfg *config.Config) breaker.Options {
+	thresholds := make(map[crawlerr.Class]float64, len(cfg.BreakerThresholds))
+	for class, t := range cfg.BreakerThresholds {
+		thresholds[crawlerr.Class(class)] = t
+	}
+
+	return breaker.Options{
+		Window:          cfg.BreakerWindow,
+		MinRequests:     cfg.BreakerMinRequests,
+		Threshold:       cfg.BreakerThreshold,
+		ClassThresholds: thresholds,
+		OpenDuration:    cfg.BreakerOpenDuration,
+		Probes:          cfg.BreakerProbes,
+	}
+}
+
 func setDefaultLogger(cfg *config.Config) {
 	level := cfg.LogLevel.ToSlog()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{